@@ -0,0 +1,91 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	configShowJSON bool
+
+	configInitDir   string
+	configInitForce bool
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   ConfigCmdUse,
+	Short: ConfigCmdShort,
+	Long:  ConfigCmdLong,
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   ConfigShowCmdUse,
+	Short: ConfigShowCmdShort,
+	Long:  ConfigShowCmdLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		if configShowJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(cfg.EffectiveConfig(false))
+		}
+
+		return cfg.PrintEffectiveConfig(os.Stdout)
+	},
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:          ConfigValidateCmdUse,
+	Short:        ConfigValidateCmdShort,
+	Long:         ConfigValidateCmdLong,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := config.GetConfig(); err != nil {
+			return err
+		}
+
+		fmt.Println("Configuration is valid")
+		return nil
+	},
+}
+
+// configInitCmd represents the config init command
+var configInitCmd = &cobra.Command{
+	Use:          ConfigInitCmdUse,
+	Short:        ConfigInitCmdShort,
+	Long:         ConfigInitCmdLong,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := config.InitConfigDir(configInitDir, configInitForce)
+		for _, file := range files {
+			fmt.Printf("Configuration file written: %s\n", file)
+		}
+
+		return err
+	},
+}
+
+func init() {
+	configShowCmd.Flags().BoolVar(&configShowJSON, "json", false, "Print the effective configuration as JSON")
+
+	configInitCmd.Flags().StringVar(&configInitDir, "dir", coreutils.GetRuntimeDir(), "Runtime directory to write the configuration to")
+	configInitCmd.Flags().BoolVar(&configInitForce, "force", false, "Overwrite existing configuration files")
+
+	configCmd.AddCommand(configShowCmd, configValidateCmd, configInitCmd)
+	rootCmd.AddCommand(configCmd)
+}
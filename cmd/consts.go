@@ -18,3 +18,75 @@ const (
 	VersionCmdShort = "Print the version number of bms-mqtt-worker-pi"
 	VersionCmdLong  = `All software has versions. This is bms-mqtt-worker-pi's`
 )
+
+// ==================== Discovery Command ====================
+const (
+	DiscoveryCmdUse   = "discovery"
+	DiscoveryCmdShort = "Inspect devices that were received but are not registered"
+	DiscoveryCmdLong  = `Inspect the unknown device registry. Every payload that refers to a device
+identifier missing from the devices database is recorded with its first and
+last seen times, customer topic, site and device name, count and a sample
+payload, so that it can be provisioned quickly.`
+
+	DiscoveryListCmdUse   = "list"
+	DiscoveryListCmdShort = "List unknown devices, most recently seen first"
+	DiscoveryListCmdLong  = `List the unknown devices recorded by the worker, most recently seen first.`
+)
+
+// ==================== Ignore Command ====================
+const (
+	IgnoreCmdUse   = "ignore"
+	IgnoreCmdShort = "Manage the controller and device ignore rules"
+	IgnoreCmdLong  = `Manage the rules that mute controllers and devices. Rules match identifiers
+exactly, by glob or by regex, can be scoped to a customer or site, and can
+expire, e.g. to mute a device while it is being commissioned. Glob and regex
+patterns must match the whole identifier.`
+
+	IgnoreListCmdUse   = "list"
+	IgnoreListCmdShort = "List the ignore rules"
+	IgnoreListCmdLong  = `List the ignore rules, including expired rules that have not been removed yet.`
+
+	IgnoreAddCmdUse   = "add <controller|device> <pattern>"
+	IgnoreAddCmdShort = "Add an ignore rule"
+	IgnoreAddCmdLong  = `Add an ignore rule for controllers or devices matching the pattern.
+
+Examples:
+  ignore add device 1912AC8630C56A0 --reason "faulty CT" --owner jan
+  ignore add controller "1912AC*" --customer acme --for 48h --reason commissioning
+  ignore add device "^PM-[0-9]+$" --match regex --site "Main Plant"`
+
+	IgnoreRemoveCmdUse   = "remove [rule-id...]"
+	IgnoreRemoveCmdShort = "Remove ignore rules"
+	IgnoreRemoveCmdLong  = `Remove ignore rules by ID, or all expired rules with --expired.`
+)
+
+// ==================== Config Command ====================
+const (
+	ConfigCmdUse   = "config"
+	ConfigCmdShort = "Inspect, validate and initialize the configuration"
+	ConfigCmdLong  = `Inspect, validate and initialize the app.yaml and system.yaml configuration
+files, e.g. from a deploy pipeline.`
+
+	ConfigShowCmdUse   = "show"
+	ConfigShowCmdShort = "Show the effective app configuration"
+	ConfigShowCmdLong  = `Show every effective app config value and where it came from: the default,
+app.yaml, an MQTT_WORKER_* environment variable or a --set flag. Secret values
+are masked.`
+
+	ConfigValidateCmdUse   = "validate"
+	ConfigValidateCmdShort = "Validate the configuration without starting the worker"
+	ConfigValidateCmdLong  = `Load and validate the configuration, including environment variable and --set
+overrides, without starting the worker or changing any files. Directories are
+checked for write access but nothing is written to them. Exits with a
+non-zero status if the configuration is invalid.`
+
+	ConfigInitCmdUse   = "init"
+	ConfigInitCmdShort = "Write the default configuration files"
+	ConfigInitCmdLong  = `Write the default app.yaml and system.yaml to the config directory of a
+runtime directory. Runtime file paths in app.yaml point into the chosen
+runtime directory.
+
+Examples:
+  config init
+  config init --dir /opt/mqtt-worker/.runtime --force`
+)
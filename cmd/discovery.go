@@ -0,0 +1,78 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/discovery"
+	"github.com/spf13/cobra"
+)
+
+var discoveryListJSON bool
+
+// discoveryCmd represents the discovery command
+var discoveryCmd = &cobra.Command{
+	Use:   DiscoveryCmdUse,
+	Short: DiscoveryCmdShort,
+	Long:  DiscoveryCmdLong,
+}
+
+// discoveryListCmd represents the discovery list command
+var discoveryListCmd = &cobra.Command{
+	Use:   DiscoveryListCmdUse,
+	Short: DiscoveryListCmdShort,
+	Long:  DiscoveryListCmdLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		devices, err := discovery.Load(cfg.App.Discovery.FilePath)
+		if err != nil {
+			return err
+		}
+
+		if discoveryListJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(devices)
+		}
+
+		if len(devices) == 0 {
+			fmt.Println("No unknown devices recorded")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE IDENTIFIER\tCUSTOMER\tSITE\tDEVICE NAME\tCOUNT\tFIRST SEEN\tLAST SEEN\tTOPIC")
+		for _, device := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				device.DeviceIdentifier,
+				device.Customer,
+				device.SiteName,
+				device.DeviceName,
+				device.Count,
+				device.FirstSeen.Format(time.RFC3339),
+				device.LastSeen.Format(time.RFC3339),
+				device.Topic,
+			)
+		}
+
+		return w.Flush()
+	},
+}
+
+func init() {
+	discoveryListCmd.Flags().BoolVar(&discoveryListJSON, "json", false, "Print the unknown devices as JSON, including sample payloads")
+
+	discoveryCmd.AddCommand(discoveryListCmd)
+	rootCmd.AddCommand(discoveryCmd)
+}
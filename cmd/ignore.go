@@ -0,0 +1,223 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/ignored"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ignoreListJSON bool
+
+	ignoreAddMatch    string
+	ignoreAddCustomer string
+	ignoreAddSite     string
+	ignoreAddReason   string
+	ignoreAddOwner    string
+	ignoreAddExpires  string
+	ignoreAddFor      time.Duration
+
+	ignoreRemoveExpired bool
+)
+
+// ignoreCmd represents the ignore command
+var ignoreCmd = &cobra.Command{
+	Use:   IgnoreCmdUse,
+	Short: IgnoreCmdShort,
+	Long:  IgnoreCmdLong,
+}
+
+// ignoreListCmd represents the ignore list command
+var ignoreListCmd = &cobra.Command{
+	Use:   IgnoreListCmdUse,
+	Short: IgnoreListCmdShort,
+	Long:  IgnoreListCmdLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		list, err := ignored.Load(cfg.App.Runtime.IgnoredFilePath)
+		if err != nil {
+			return err
+		}
+
+		if ignoreListJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(list.Rules)
+		}
+
+		if len(list.Rules) == 0 {
+			fmt.Println("No ignore rules")
+			return nil
+		}
+
+		now := time.Now()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tKIND\tPATTERN\tMATCH\tCUSTOMER\tSITE\tEXPIRES\tOWNER\tREASON")
+		for _, rule := range list.Rules {
+			expires := "never"
+			if rule.ExpiresAt != nil {
+				expires = rule.ExpiresAt.Format(time.RFC3339)
+				if ignored.IsExpired(rule, now) {
+					expires += " (expired)"
+				}
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				rule.ID,
+				rule.Kind,
+				rule.Pattern,
+				rule.Match,
+				rule.Customer,
+				rule.Site,
+				expires,
+				rule.Owner,
+				rule.Reason,
+			)
+		}
+
+		return w.Flush()
+	},
+}
+
+// ignoreAddCmd represents the ignore add command
+var ignoreAddCmd = &cobra.Command{
+	Use:   IgnoreAddCmdUse,
+	Short: IgnoreAddCmdShort,
+	Long:  IgnoreAddCmdLong,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		now := time.Now()
+
+		rule := types.IgnoreRule{
+			ID:        ignored.NewRuleID(),
+			Kind:      args[0],
+			Pattern:   args[1],
+			Match:     ignoreAddMatch,
+			Customer:  ignoreAddCustomer,
+			Site:      ignoreAddSite,
+			Reason:    ignoreAddReason,
+			Owner:     ignoreAddOwner,
+			CreatedAt: now,
+		}
+
+		if ignoreAddExpires != "" && ignoreAddFor != 0 {
+			return fmt.Errorf("--expires and --for cannot be used together")
+		}
+
+		if ignoreAddExpires != "" {
+			expiresAt, err := time.Parse(time.RFC3339, ignoreAddExpires)
+			if err != nil {
+				return fmt.Errorf("invalid expiry time: %w", err)
+			}
+			rule.ExpiresAt = &expiresAt
+		}
+
+		if ignoreAddFor != 0 {
+			expiresAt := now.Add(ignoreAddFor)
+			rule.ExpiresAt = &expiresAt
+		}
+
+		if err := ignored.Validate(rule); err != nil {
+			return err
+		}
+
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		err = ignored.Update(cfg.App.Runtime.IgnoredFilePath, func(list *types.IgnoredControllersAndDevices) error {
+			list.Rules = append(list.Rules, rule)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added ignore rule %s\n", rule.ID)
+
+		return nil
+	},
+}
+
+// ignoreRemoveCmd represents the ignore remove command
+var ignoreRemoveCmd = &cobra.Command{
+	Use:   IgnoreRemoveCmdUse,
+	Short: IgnoreRemoveCmdShort,
+	Long:  IgnoreRemoveCmdLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !ignoreRemoveExpired {
+			return fmt.Errorf("specify rule IDs or --expired")
+		}
+
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var removed []string
+
+		err = ignored.Update(cfg.App.Runtime.IgnoredFilePath, func(list *types.IgnoredControllersAndDevices) error {
+			for _, id := range args {
+				if !slices.ContainsFunc(list.Rules, func(rule types.IgnoreRule) bool { return rule.ID == id }) {
+					return fmt.Errorf("ignore rule not found: %s", id)
+				}
+			}
+
+			list.Rules = slices.DeleteFunc(list.Rules, func(rule types.IgnoreRule) bool {
+				if slices.Contains(args, rule.ID) || (ignoreRemoveExpired && ignored.IsExpired(rule, now)) {
+					removed = append(removed, rule.ID)
+					return true
+				}
+				return false
+			})
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range removed {
+			fmt.Printf("Removed ignore rule %s\n", id)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	ignoreListCmd.Flags().BoolVar(&ignoreListJSON, "json", false, "Print the ignore rules as JSON")
+
+	ignoreAddCmd.Flags().StringVar(&ignoreAddMatch, "match", types.IgnoreMatchGlob, "How the pattern is matched (exact, glob, regex)")
+	ignoreAddCmd.Flags().StringVar(&ignoreAddCustomer, "customer", "", "Only apply the rule to this customer")
+	ignoreAddCmd.Flags().StringVar(&ignoreAddSite, "site", "", "Only apply the rule to this site")
+	ignoreAddCmd.Flags().StringVar(&ignoreAddReason, "reason", "", "Why the controller or device is ignored")
+	ignoreAddCmd.Flags().StringVar(&ignoreAddOwner, "owner", "", "Who is responsible for the rule")
+	ignoreAddCmd.Flags().StringVar(&ignoreAddExpires, "expires", "", "Time the rule expires (RFC3339)")
+	ignoreAddCmd.Flags().DurationVar(&ignoreAddFor, "for", 0, "Duration after which the rule expires (e.g. 48h)")
+
+	ignoreRemoveCmd.Flags().BoolVar(&ignoreRemoveExpired, "expired", false, "Remove all expired rules")
+
+	ignoreCmd.AddCommand(ignoreListCmd)
+	ignoreCmd.AddCommand(ignoreAddCmd)
+	ignoreCmd.AddCommand(ignoreRemoveCmd)
+	rootCmd.AddCommand(ignoreCmd)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/johandrevandeventer/mqtt-worker/internal/config"
 	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/splashscreen"
 	"github.com/spf13/cobra"
 )
 
@@ -17,12 +18,12 @@ var rootCmd = &cobra.Command{
 	Short: RootCmdShort,
 	Long:  RootCmdLong,
 
+	// The banner is only printed when running the engine, so that subcommand
+	// output such as config show --json stays machine readable
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if cmd.CalledAs() == RootCmdUse {
+		if !cmd.HasParent() {
+			splashscreen.PrintSplashScreen()
 			config.PrintInfo(false)
-		} else {
-			config.PrintInfo(true)
-			os.Exit(0)
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -31,7 +32,7 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	err := rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
 	if err != nil {
 		os.Exit(1)
 	}
@@ -41,6 +42,11 @@ func Execute() {
 	if helpFlag {
 		os.Exit(0)
 	}
+
+	// Only the root command starts the engine, subcommands exit once they are done
+	if cmd != rootCmd {
+		os.Exit(0)
+	}
 }
 
 func init() {
@@ -51,4 +57,6 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&flags.FlagLogPrefix, "log-prefix", true, "Add timestamps to logs and subprocess stderr/stdout output")
 	rootCmd.PersistentFlags().BoolVar(&flags.FlagKafkaLogging, "kafka-logging", false, "Enable Kafka logging (default false)")
 	rootCmd.PersistentFlags().BoolVar(&flags.FlagWorkersLogging, "workers-logging", false, "Enable workers logging (default false)")
+	rootCmd.PersistentFlags().StringArrayVar(&flags.FlagConfigOverrides, "set", nil, "Override an app config value, e.g. --set logging.level=debug (can be repeated)")
+	rootCmd.Flags().BoolVar(&flags.FlagPrintConfig, "print-config", false, "Print the effective app config and where each value came from, then exit")
 }
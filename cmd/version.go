@@ -4,6 +4,7 @@ Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
 package cmd
 
 import (
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
 	"github.com/spf13/cobra"
 )
 
@@ -13,6 +14,7 @@ var versionCmd = &cobra.Command{
 	Short: VersionCmdShort,
 	Long:  VersionCmdLong,
 	Run: func(cmd *cobra.Command, args []string) {
+		config.PrintInfo(true)
 	},
 }
 
@@ -27,5 +27,14 @@ func InitConfig() error {
 		}
 	}
 
+	backupFilePath, err := config.MigrateConfig()
+	if err != nil {
+		return err
+	}
+
+	if backupFilePath != "" {
+		coreutils.VerbosePrintln(textutils.ColorText(textutils.Yellow, fmt.Sprintf("-> Configuration file migrated, original saved to: %s", backupFilePath)))
+	}
+
 	return nil
 }
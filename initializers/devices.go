@@ -0,0 +1,13 @@
+package initializers
+
+import (
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/devicerepo"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+)
+
+// InitDeviceRepository creates the repository devices are looked up in
+func InitDeviceRepository(cfg *config.Config) (workers.DeviceRepository, error) {
+	return devicerepo.New(&cfg.App.Devices, loglevel.Logger("devices"))
+}
@@ -4,10 +4,12 @@ import (
 	"github.com/johandrevandeventer/logging"
 	"github.com/johandrevandeventer/mqtt-worker/internal/config"
 	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"go.uber.org/zap/zapcore"
 )
 
 // InitLogger configures the logger based on the app config
-func InitLogger(cfg *config.Config) {
+func InitLogger(cfg *config.Config) error {
 	// Create a new logging config with values from the app config
 
 	var logPrefix bool
@@ -18,8 +20,16 @@ func InitLogger(cfg *config.Config) {
 		logPrefix = true
 	}
 
+	// The base logger logs everything, the configured level is applied by
+	// loglevel so that it can be changed at runtime
+	level, err := zapcore.ParseLevel(cfg.App.Logging.Level)
+	if err != nil {
+		return err
+	}
+	loglevel.SetLevel(level)
+
 	loggingConfig := logging.NewLoggingConfig(
-		cfg.App.Logging.Level,
+		zapcore.DebugLevel.String(),
 		cfg.App.Logging.FilePath,
 		cfg.App.Logging.MaxSize,
 		cfg.App.Logging.MaxBackups,
@@ -31,4 +41,6 @@ func InitLogger(cfg *config.Config) {
 
 	// Get a new logger based on the config
 	_ = logging.NewLogger(loggingConfig)
+
+	return nil
 }
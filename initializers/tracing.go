@@ -0,0 +1,14 @@
+package initializers
+
+import (
+	"context"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
+)
+
+// InitTracing initializes the tracer provider. The returned function flushes
+// pending spans and must be called on shutdown.
+func InitTracing(cfg *config.Config) (func(context.Context) error, error) {
+	return tracing.Init(&cfg.App.Tracing, cfg.System.AppVersion)
+}
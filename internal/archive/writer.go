@@ -0,0 +1,270 @@
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+const (
+	// dayLayout names the daily archive directories
+	dayLayout = "2006-01-02"
+
+	// UnknownCustomer is the archive file of messages whose customer could not
+	// be resolved
+	UnknownCustomer = "_unknown"
+
+	cleanupInterval = 1 * time.Hour
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Writer appends consumed messages to gzip compressed JSONL files, one per day
+// and customer: <dir>/<YYYY-MM-DD>/<customer>.jsonl.gz. Days are in UTC.
+//
+// Every time a file is opened a new gzip member is started, which gzip readers
+// read as one stream.
+type Writer struct {
+	cfg    *app.ArchiveConfig
+	logger *zap.Logger
+	mu     sync.Mutex
+	day    string
+	files  map[string]*archiveFile
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type archiveFile struct {
+	file *os.File
+	gz   *gzip.Writer
+}
+
+// NewWriter creates a new Writer and starts its background flusher and
+// retention cleanup
+func NewWriter(cfg *app.ArchiveConfig, logger *zap.Logger) (*Writer, error) {
+	if cfg.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid archive flush interval: %d", cfg.FlushInterval)
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	w := &Writer{
+		cfg:    cfg,
+		logger: logger,
+		files:  make(map[string]*archiveFile),
+		done:   make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w, nil
+}
+
+// Write appends a serialized payload to the archive file of the customer for
+// the day of t. Writes are accepted until the writer is closed, so messages
+// still being processed during shutdown are archived.
+func (w *Writer) Write(customer string, data []byte, t time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fmt.Errorf("archive writer closed")
+	}
+
+	day := t.UTC().Format(dayLayout)
+	if day != w.day {
+		// A new day started, the previous day's files are complete
+		w.closeFiles()
+		w.day = day
+	}
+
+	f, err := w.file(day, customerFileName(customer))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.gz.Write(data); err != nil {
+		return fmt.Errorf("failed to write to archive: %w", err)
+	}
+	if _, err := f.gz.Write([]byte("\n")); err != nil {
+		return fmt.Errorf("failed to write to archive: %w", err)
+	}
+
+	return nil
+}
+
+// file returns the open archive file of a customer, opening it if needed
+func (w *Writer) file(day string, name string) (*archiveFile, error) {
+	if f, ok := w.files[name]; ok {
+		return f, nil
+	}
+
+	dir := filepath.Join(w.cfg.Dir, day)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, name+".jsonl.gz"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+
+	f := &archiveFile{file: file, gz: gzip.NewWriter(file)}
+	w.files[name] = f
+
+	return f, nil
+}
+
+// customerFileName returns a file name for a customer. Names that had to be
+// sanitized get a short hash of the customer appended, so that different
+// customers never share a file and none shares the UnknownCustomer file.
+func customerFileName(customer string) string {
+	if customer == UnknownCustomer {
+		return UnknownCustomer
+	}
+
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(customer, "_"), "._")
+	if name == customer && name != "" {
+		return name
+	}
+
+	if name == "" {
+		name = "customer"
+	}
+
+	sum := sha256.Sum256([]byte(customer))
+	return name + "-" + hex.EncodeToString(sum[:4])
+}
+
+// Flush writes the buffered messages of the open files to disk
+func (w *Writer) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for name, f := range w.files {
+		if err := f.gz.Flush(); err != nil {
+			w.logger.Error("Failed to flush archive file", zap.String("customer", name), zap.Error(err))
+		}
+	}
+}
+
+// closeFiles closes all open archive files
+func (w *Writer) closeFiles() {
+	for name, f := range w.files {
+		if err := f.gz.Close(); err != nil {
+			w.logger.Error("Failed to close archive file", zap.String("customer", name), zap.Error(err))
+		}
+		if err := f.file.Close(); err != nil {
+			w.logger.Error("Failed to close archive file", zap.String("customer", name), zap.Error(err))
+		}
+	}
+
+	w.files = make(map[string]*archiveFile)
+}
+
+// Cleanup removes the day directories that are older than the retention
+func (w *Writer) Cleanup(now time.Time) (int, error) {
+	if w.cfg.RetentionDays <= 0 {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(w.cfg.Dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+
+	cutoff := now.UTC().AddDate(0, 0, -w.cfg.RetentionDays).Format(dayLayout)
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// Skip directories that are not archive days
+		if _, err := time.Parse(dayLayout, entry.Name()); err != nil {
+			continue
+		}
+
+		if entry.Name() >= cutoff {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(w.cfg.Dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove archive day %s: %w", entry.Name(), err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// run flushes the open files by flush interval and removes expired days
+func (w *Writer) run() {
+	defer w.wg.Done()
+
+	flushTicker := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Second)
+	defer flushTicker.Stop()
+
+	cleanupTicker := time.NewTicker(cleanupInterval)
+	defer cleanupTicker.Stop()
+
+	w.cleanup()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-flushTicker.C:
+			w.Flush()
+		case <-cleanupTicker.C:
+			w.cleanup()
+		}
+	}
+}
+
+// cleanup removes expired days and logs the result
+func (w *Writer) cleanup() {
+	removed, err := w.Cleanup(time.Now())
+	if err != nil {
+		w.logger.Error("Failed to clean up archive", zap.Error(err))
+		return
+	}
+
+	if removed > 0 {
+		w.logger.Info("Removed expired archive days", zap.Int("days", removed), zap.Int("retention_days", w.cfg.RetentionDays))
+	}
+}
+
+// Close stops the background flusher and closes the archive files. Writes
+// after Close return an error.
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	w.closeFiles()
+	w.mu.Unlock()
+}
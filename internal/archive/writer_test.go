@@ -0,0 +1,183 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+func newTestWriter(t *testing.T, retentionDays int) *Writer {
+	t.Helper()
+
+	w, err := NewWriter(&app.ArchiveConfig{Dir: t.TempDir(), RetentionDays: retentionDays, FlushInterval: 60}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Close)
+
+	return w
+}
+
+func write(t *testing.T, w *Writer, customer string, message string, at time.Time) {
+	t.Helper()
+
+	if err := w.Write(customer, []byte(message), at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readLines reads an archive file of a day and customer, gzip members included
+func readLines(t *testing.T, w *Writer, day string, name string) []string {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join(w.cfg.Dir, day, name+".jsonl.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func assertLines(t *testing.T, w *Writer, day string, name string, want ...string) {
+	t.Helper()
+
+	if got := readLines(t, w, day, name); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s/%s holds %q, want %q", day, name, got, want)
+	}
+}
+
+func TestDayRotation(t *testing.T) {
+	w := newTestWriter(t, 0)
+
+	// Days are in UTC, 23:30 in Johannesburg is still the 14th in UTC
+	johannesburg := time.FixedZone("SAST", 2*60*60)
+	first := time.Date(2025, 3, 15, 0, 30, 0, 0, johannesburg)
+	second := time.Date(2025, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	write(t, w, "Acme", `{"n":1}`, first)
+	write(t, w, "Globex", `{"n":2}`, first)
+	write(t, w, "Acme", `{"n":3}`, second)
+
+	// A late message of the previous day appends a gzip member to its file
+	write(t, w, "Acme", `{"n":4}`, first)
+	w.Close()
+
+	assertLines(t, w, "2025-03-14", "Acme", `{"n":1}`, `{"n":4}`)
+	assertLines(t, w, "2025-03-14", "Globex", `{"n":2}`)
+	assertLines(t, w, "2025-03-15", "Acme", `{"n":3}`)
+}
+
+func TestWriteUntilClose(t *testing.T) {
+	w := newTestWriter(t, 0)
+	at := time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC)
+
+	write(t, w, "Acme", `{"n":1}`, at)
+	w.Close()
+
+	if err := w.Write("Acme", []byte(`{"n":2}`), at); err == nil {
+		t.Error("closed writer accepted a message")
+	}
+
+	assertLines(t, w, "2025-03-14", "Acme", `{"n":1}`)
+}
+
+func TestCustomerFileName(t *testing.T) {
+	tests := []struct {
+		customer string
+		want     string
+	}{
+		{customer: "Acme", want: "Acme"},
+		{customer: "acme-2.0_east", want: "acme-2.0_east"},
+		{customer: "Acme Corp", want: "Acme_Corp-a73cb456"},
+		{customer: "../etc/passwd", want: "etc_passwd-7fef78f5"},
+		{customer: "***", want: "customer-596f4162"},
+		{customer: "", want: "customer-e3b0c442"},
+		{customer: UnknownCustomer, want: UnknownCustomer},
+		{customer: "._unknown", want: "unknown-fab63b97"},
+		{customer: "unknown", want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := customerFileName(tt.customer); got != tt.want {
+			t.Errorf("customerFileName(%q) = %q, want %q", tt.customer, got, tt.want)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	now := time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC)
+
+	// Days older than the retention are removed, other entries are kept
+	entries := []string{"2025-03-01", "2025-03-06", "2025-03-07", "2025-03-13", "2025-03-14", "exports"}
+
+	tests := []struct {
+		name          string
+		retentionDays int
+		removed       int
+		kept          []string
+	}{
+		{name: "retention", retentionDays: 7, removed: 2, kept: []string{"2025-03-07", "2025-03-13", "2025-03-14", "exports", "notes.txt"}},
+		{name: "no retention", retentionDays: 0, kept: append(entries, "notes.txt")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWriter(t, tt.retentionDays)
+
+			for _, entry := range entries {
+				if err := os.MkdirAll(filepath.Join(w.cfg.Dir, entry), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(w.cfg.Dir, "notes.txt"), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			removed, err := w.Cleanup(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.removed {
+				t.Errorf("removed %d days, want %d", removed, tt.removed)
+			}
+
+			dirEntries, err := os.ReadDir(w.cfg.Dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var kept []string
+			for _, entry := range dirEntries {
+				kept = append(kept, entry.Name())
+			}
+			sort.Strings(kept)
+
+			if fmt.Sprint(kept) != fmt.Sprint(tt.kept) {
+				t.Errorf("kept %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
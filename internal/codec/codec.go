@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EnvelopeVersion is the version of the envelope records and batches are
+// encoded in. It must be increased when the envelope changes incompatibly.
+const EnvelopeVersion = 1
+
+// HeaderContentType is the Kafka header that carries the content type of an
+// encoded record
+const HeaderContentType = "content-type"
+
+// Record is an output of a consumed message. Data is the output itself, such
+// as a *types.DataStruct or a Kodelabs message, and ID is the ID of the
+// consumed message it was produced from.
+type Record struct {
+	ID        uuid.UUID
+	Timestamp time.Time
+	Data      any
+}
+
+// Codec encodes output records for Kafka
+type Codec interface {
+	// Name is the name the codec is configured by
+	Name() string
+	// ContentType is sent in the content-type header of encoded records
+	ContentType() string
+	// Encode encodes a single record
+	Encode(record Record) ([]byte, error)
+	// EncodeBatch encodes records into one batch envelope
+	EncodeBatch(records []Record) ([]byte, error)
+}
+
+var codecs = map[string]Codec{
+	JSON{}.Name():        JSON{},
+	MessagePack{}.Name(): MessagePack{},
+	Protobuf{}.Name():    Protobuf{},
+}
+
+// Get returns the codec with the given name
+func Get(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+
+	return codec, nil
+}
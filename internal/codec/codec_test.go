@@ -0,0 +1,249 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	testTimestamp = time.Date(2025, 3, 14, 10, 15, 30, 0, time.UTC)
+
+	testDataStruct = &types.DataStruct{
+		State:                types.DataStatePost,
+		CustomerID:           uuid.MustParse("6f1c2a44-0d5e-4c4b-9a63-1a2b3c4d5e01"),
+		CustomerName:         "Acme",
+		SiteID:               uuid.MustParse("0b7e9c1d-8a2f-4e3b-bf51-2c3d4e5f6a01"),
+		SiteName:             "Acme Head Office",
+		Controller:           "CloudWatch",
+		DeviceType:           "PowerMeter",
+		ControllerIdentifier: "CW-1001",
+		DeviceName:           "Main Incomer",
+		DeviceIdentifier:     "CW-1001",
+		Data:                 map[string]any{"V1": 231.4, "I1": 12.5},
+		Timestamp:            testTimestamp,
+	}
+
+	// testRecords are a DataStruct and a Kodelabs-like output of one message
+	testRecords = []Record{
+		{ID: uuid.MustParse("2f6c1d3e-4a5b-4c6d-8e7f-9a0b1c2d3e4f"), Timestamp: testTimestamp, Data: testDataStruct},
+		{ID: uuid.MustParse("2f6c1d3e-4a5b-4c6d-8e7f-9a0b1c2d3e4f"), Timestamp: testTimestamp, Data: map[string]any{"customer": "Acme", "points": []any{"V1"}}},
+	}
+)
+
+// decodedEnvelope is an envelope decoded by a consumer. The data of a
+// DataStruct is compared by its customer and device.
+type decodedEnvelope struct {
+	Version int
+	Count   int
+	Items   []decodedItem
+}
+
+type decodedItem struct {
+	ID        string
+	Timestamp time.Time
+	Customer  string
+	Device    string
+	V1        float64
+}
+
+func TestEncode(t *testing.T) {
+	for _, c := range []Codec{JSON{}, MessagePack{}, Protobuf{}} {
+		t.Run(c.Name(), func(t *testing.T) {
+			data, err := c.Encode(testRecords[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Single records are an envelope of one
+			got := decode(t, c, data)
+			if got.Version != EnvelopeVersion || got.Count != 1 || len(got.Items) != 1 {
+				t.Fatalf("decoded a version %d envelope of %d items, want version %d of 1", got.Version, len(got.Items), EnvelopeVersion)
+			}
+
+			want := decodedItem{ID: testRecords[0].ID.String(), Timestamp: testTimestamp, Customer: "Acme", Device: "CW-1001", V1: 231.4}
+			if item := got.Items[0]; item != want {
+				t.Errorf("decoded %+v, want %+v", item, want)
+			}
+		})
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	for _, c := range []Codec{JSON{}, MessagePack{}, Protobuf{}} {
+		t.Run(c.Name(), func(t *testing.T) {
+			data, err := c.EncodeBatch(testRecords)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := decode(t, c, data)
+			if got.Version != EnvelopeVersion || got.Count != len(testRecords) || len(got.Items) != len(testRecords) {
+				t.Fatalf("decoded a version %d envelope with count %d and %d items, want version %d with %d", got.Version, got.Count, len(got.Items), EnvelopeVersion, len(testRecords))
+			}
+
+			for i, item := range got.Items {
+				if item.ID != testRecords[i].ID.String() || !item.Timestamp.Equal(testTimestamp) {
+					t.Errorf("item %d is %s at %s, want %s at %s", i, item.ID, item.Timestamp, testRecords[i].ID, testTimestamp)
+				}
+			}
+			if got.Items[1].Customer != "Acme" {
+				t.Errorf("output of item 1 is for %q, want Acme", got.Items[1].Customer)
+			}
+		})
+	}
+}
+
+func TestJSONContentTypeCarriesVersion(t *testing.T) {
+	if got, want := (JSON{}).ContentType(), "application/json; envelope=1"; got != want {
+		t.Errorf("content type is %q, want %q", got, want)
+	}
+}
+
+// decode decodes an envelope the way a consumer would, with a plain JSON or
+// MessagePack decoder, or with output.proto for Protobuf
+func decode(t *testing.T, c Codec, data []byte) decodedEnvelope {
+	t.Helper()
+
+	switch c.(type) {
+	case JSON:
+		var envelope struct {
+			Version int `json:"version"`
+			Count   int `json:"count"`
+			Items   []struct {
+				ID        string          `json:"id"`
+				Timestamp time.Time       `json:"timestamp"`
+				Data      json.RawMessage `json:"data"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := decodedEnvelope{Version: envelope.Version, Count: envelope.Count}
+		for _, item := range envelope.Items {
+			var output map[string]any
+			if err := json.Unmarshal(item.Data, &output); err != nil {
+				t.Fatal(err)
+			}
+			decoded.Items = append(decoded.Items, decodedOutput(item.ID, item.Timestamp, output))
+		}
+
+		return decoded
+
+	case MessagePack:
+		var envelope struct {
+			Version int `msgpack:"version"`
+			Count   int `msgpack:"count"`
+			Items   []struct {
+				ID        uuid.UUID      `msgpack:"id"`
+				Timestamp time.Time      `msgpack:"timestamp"`
+				Data      map[string]any `msgpack:"data"`
+			} `msgpack:"items"`
+		}
+		if err := msgpack.Unmarshal(data, &envelope); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := decodedEnvelope{Version: envelope.Version, Count: envelope.Count}
+		for _, item := range envelope.Items {
+			decoded.Items = append(decoded.Items, decodedOutput(item.ID.String(), item.Timestamp, item.Data))
+		}
+
+		return decoded
+
+	case Protobuf:
+		envelope := dynamicpb.NewMessage(outputMessage(t, "Envelope"))
+		if err := proto.Unmarshal(data, envelope); err != nil {
+			t.Fatal(err)
+		}
+
+		// protojson gives the JSON mapping of the well-known types
+		encoded, err := protojson.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded struct {
+			Version int `json:"version"`
+			Count   int `json:"count"`
+			Items   []struct {
+				ID         string         `json:"id"`
+				Timestamp  time.Time      `json:"timestamp"`
+				DataStruct map[string]any `json:"dataStruct"`
+				Value      map[string]any `json:"value"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		result := decodedEnvelope{Version: decoded.Version, Count: decoded.Count}
+		for _, item := range decoded.Items {
+			output := item.Value
+			if item.DataStruct != nil {
+				output = map[string]any{
+					"CustomerName":     item.DataStruct["customerName"],
+					"DeviceIdentifier": item.DataStruct["deviceIdentifier"],
+					"Data":             item.DataStruct["data"],
+				}
+			}
+			result.Items = append(result.Items, decodedOutput(item.ID, item.Timestamp, output))
+		}
+
+		return result
+	}
+
+	t.Fatalf("no decoder for codec %s", c.Name())
+	return decodedEnvelope{}
+}
+
+// decodedOutput picks the compared fields of a decoded DataStruct or
+// Kodelabs-like output
+func decodedOutput(id string, timestamp time.Time, output map[string]any) decodedItem {
+	item := decodedItem{ID: id, Timestamp: timestamp.UTC()}
+
+	if customer, ok := output["customer"].(string); ok {
+		item.Customer = customer
+		return item
+	}
+
+	item.Customer, _ = output["CustomerName"].(string)
+	item.Device, _ = output["DeviceIdentifier"].(string)
+	if data, ok := output["Data"].(map[string]any); ok {
+		item.V1, _ = data["V1"].(float64)
+	}
+
+	return item
+}
+
+// outputMessage returns a message descriptor of output.proto
+func outputMessage(t *testing.T, name protoreflect.Name) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{}),
+	}
+
+	files, err := compiler.Compile(context.Background(), "output.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := files[0].Messages().ByName(name)
+	if message == nil {
+		t.Fatalf("output.proto has no message %s", name)
+	}
+
+	return message
+}
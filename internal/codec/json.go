@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// envelope is a batch of records, in the shape of the Envelope message of
+// output.proto. Single records are encoded as an envelope of one.
+type envelope struct {
+	Version int    `json:"version"`
+	Count   int    `json:"count"`
+	Items   []item `json:"items"`
+}
+
+type item struct {
+	ID        uuid.UUID `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+func newEnvelope(records []Record) envelope {
+	items := make([]item, 0, len(records))
+	for _, record := range records {
+		items = append(items, item{ID: record.ID, Timestamp: record.Timestamp, Data: record.Data})
+	}
+
+	return envelope{Version: EnvelopeVersion, Count: len(items), Items: items}
+}
+
+// JSON encodes records and batches as a JSON envelope. Before codecs were
+// configurable, records were a payload.Payload holding the output encoded a
+// second time, so the content type carries the envelope version to let
+// consumers tell the formats apart.
+type JSON struct{}
+
+func (JSON) Name() string {
+	return "json"
+}
+
+func (JSON) ContentType() string {
+	return "application/json; envelope=" + strconv.Itoa(EnvelopeVersion)
+}
+
+func (j JSON) Encode(record Record) ([]byte, error) {
+	return j.EncodeBatch([]Record{record})
+}
+
+func (JSON) EncodeBatch(records []Record) ([]byte, error) {
+	data, err := json.Marshal(newEnvelope(records))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MessagePack encodes records and batches as a MessagePack envelope. Keys are
+// the JSON field names, UUIDs are 16 byte binaries and times use the
+// MessagePack timestamp extension.
+type MessagePack struct{}
+
+func (MessagePack) Name() string {
+	return "msgpack"
+}
+
+func (MessagePack) ContentType() string {
+	return "application/msgpack"
+}
+
+func (m MessagePack) Encode(record Record) ([]byte, error) {
+	return m.EncodeBatch([]Record{record})
+}
+
+func (MessagePack) EncodeBatch(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+
+	if err := encoder.Encode(newEnvelope(records)); err != nil {
+		return nil, fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,184 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Protobuf encodes records and batches as the Envelope message of
+// output.proto. The messages are small and stable, so they are written with
+// protowire instead of generated code.
+type Protobuf struct{}
+
+func (Protobuf) Name() string {
+	return "protobuf"
+}
+
+func (Protobuf) ContentType() string {
+	return "application/x-protobuf; messageType=mqttworker.output.v1.Envelope"
+}
+
+func (p Protobuf) Encode(record Record) ([]byte, error) {
+	return p.EncodeBatch([]Record{record})
+}
+
+func (Protobuf) EncodeBatch(records []Record) ([]byte, error) {
+	var b []byte
+	b = appendUint(b, 1, EnvelopeVersion)
+	b = appendUint(b, 2, uint64(len(records)))
+
+	for _, record := range records {
+		item, err := encodeItem(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode batch: %w", err)
+		}
+		b = appendMessage(b, 3, item)
+	}
+
+	return b, nil
+}
+
+// encodeItem encodes a record as an Item message
+func encodeItem(record Record) ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, record.ID.String())
+
+	timestamp, err := encodeTimestamp(record.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	b = appendMessage(b, 2, timestamp)
+
+	if ds, ok := record.Data.(*types.DataStruct); ok {
+		data, err := encodeDataStruct(ds)
+		if err != nil {
+			return nil, err
+		}
+		return appendMessage(b, 3, data), nil
+	}
+
+	value, err := toValue(record.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	return appendMessage(b, 4, data), nil
+}
+
+// encodeDataStruct encodes a DataStruct message
+func encodeDataStruct(ds *types.DataStruct) ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, ds.State)
+	b = appendUUID(b, 2, ds.CustomerID)
+	b = appendString(b, 3, ds.CustomerName)
+	b = appendUUID(b, 4, ds.SiteID)
+	b = appendString(b, 5, ds.SiteName)
+	b = appendString(b, 6, ds.Controller)
+	b = appendString(b, 7, ds.DeviceType)
+	b = appendString(b, 8, ds.ControllerIdentifier)
+	b = appendString(b, 9, ds.DeviceName)
+	b = appendString(b, 10, ds.DeviceIdentifier)
+
+	if ds.Data != nil {
+		data, err := structpb.NewStruct(ds.Data)
+		if err != nil {
+			// Decoders may put values in the map that structpb does not
+			// know, such as typed slices; their JSON shape is kept instead
+			value, err := toValue(ds.Data)
+			if err != nil {
+				return nil, err
+			}
+			data = value.GetStructValue()
+		}
+
+		encoded, err := proto.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode data: %w", err)
+		}
+		b = appendMessage(b, 11, encoded)
+	}
+
+	timestamp, err := encodeTimestamp(ds.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendMessage(b, 12, timestamp), nil
+}
+
+// toValue converts a value to a google.protobuf.Value of its JSON shape
+func toValue(v any) (*structpb.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	value, err := structpb.NewValue(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	return value, nil
+}
+
+func encodeTimestamp(t time.Time) ([]byte, error) {
+	data, err := proto.Marshal(timestamppb.New(t))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode timestamp: %w", err)
+	}
+
+	return data, nil
+}
+
+// appendUint appends a varint field, proto3 omits zero values
+func appendUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendString appends a string field, proto3 omits empty strings
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendUUID appends a UUID as a string field, the nil UUID is omitted
+func appendUUID(b []byte, num protowire.Number, id uuid.UUID) []byte {
+	if id == uuid.Nil {
+		return b
+	}
+
+	return appendString(b, num, id.String())
+}
+
+// appendMessage appends an encoded message field
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
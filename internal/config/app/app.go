@@ -1,57 +1,154 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+	"gopkg.in/yaml.v2"
 )
 
-var (
-	appConfig *AppConfig
+var appConfig = newDefaultAppConfig()
 
-	// Default configurations
-	defaultAppConfig     *AppConfig
-	defaultRuntimeConfig *RuntimeConfig
-	defaultLoggingConfig *LoggingConfig
-
-	// File paths
-	persistFilePath        = filepath.Join(coreutils.GetPersistDir(), "persist.json")
-	loggingFilePath        = filepath.Join(coreutils.GetLoggingDir(), "app.jsonl")
-	stopFileFilePath       = filepath.Join(coreutils.GetTmpDir(), "stop_signal")
-	connectionsLogFilePath = filepath.Join(coreutils.GetConnectionsDir(), "connections.log")
-)
-
-func init() {
-	// persistFilePath = filepath.Join(coreutils.GetPersistDir(), "persist.json")
-	// loggingFilePath = filepath.Join(coreutils.GetLoggingDir(), "app.jsonl")
-	// stopFileFilePath = filepath.Join(coreutils.GetTmpDir(), "stop_signal")
-	// connectionsLogFilePath = filepath.Join(coreutils.GetConnectionsDir(), "connections.log")
-
-	defaultRuntimeConfig = &RuntimeConfig{
-		RootDir:                coreutils.GetRootDir(),
-		TmpDir:                 coreutils.GetTmpDir(),
-		PersistFilePath:        persistFilePath,
-		StopFileFilepath:       stopFileFilePath,
-		ConnectionsLogFilePath: connectionsLogFilePath,
-	}
-
-	defaultLoggingConfig = &LoggingConfig{
-		Level:      "info",
-		FilePath:   loggingFilePath,
-		MaxSize:    100,
-		MaxBackups: 3,
-		MaxAge:     28,
-		Compress:   true,
-		AddTime:    true,
-	}
+// newDefaultAppConfig returns a fresh copy of the default app configuration.
+// A new copy is built every time so that decoding a file never mutates the defaults.
+func newDefaultAppConfig() *AppConfig {
+	return DefaultAppConfig(coreutils.GetRuntimeDir())
+}
 
-	defaultAppConfig = &AppConfig{
-		Runtime: *defaultRuntimeConfig,
-		Logging: *defaultLoggingConfig,
+// DefaultAppConfig returns the default app configuration with all runtime files
+// placed in the given runtime directory
+func DefaultAppConfig(runtimeDir string) *AppConfig {
+	return &AppConfig{
+		ConfigVersion: CurrentConfigVersion,
+		Runtime: RuntimeConfig{
+			RootDir:                coreutils.GetRootDir(),
+			TmpDir:                 filepath.Join(runtimeDir, "tmp"),
+			PersistFilePath:        filepath.Join(runtimeDir, "persist", "persist.json"),
+			StopFileFilepath:       filepath.Join(runtimeDir, "tmp", "stop_signal"),
+			ConnectionsLogFilePath: filepath.Join(runtimeDir, "connections", "connections.log"),
+			IgnoredFilePath:        filepath.Join(coreutils.GetRootDir(), "internal", "workers", "ignored", "ignored.json"),
+			ControlDir:             filepath.Join(runtimeDir, "control"),
+		},
+		Logging: LoggingConfig{
+			Level:      "info",
+			FilePath:   filepath.Join(runtimeDir, "logs", "app.jsonl"),
+			MaxSize:    100,
+			MaxBackups: 3,
+			MaxAge:     28,
+			Compress:   true,
+			AddTime:    true,
+		},
+		InfluxDB: InfluxDBConfig{
+			Enabled:     false,
+			URL:         "http://localhost:8086",
+			Org:         "rubicon",
+			Bucket:      "rubicon",
+			Token:       "",
+			Precision:   "ms",
+			Measurement: "device_data",
+			Tags: map[string]string{
+				"state":             "state",
+				"customer":          "customer",
+				"site":              "site",
+				"device_type":       "device_type",
+				"device_identifier": "device_identifier",
+			},
+			Fields:        map[string]string{},
+			BatchSize:     500,
+			FlushInterval: 1,
+			Gzip:          true,
+			MaxRetries:    3,
+			RetryInterval: 1,
+			Timeout:       10,
+		},
+		Kodelabs: KodelabsConfig{
+			MappingDir: filepath.Join(runtimeDir, "config", "kodelabs"),
+		},
+		Stats: StatsConfig{
+			FlushInterval: 10,
+		},
+		Liveness: LivenessConfig{
+			Enabled:         false,
+			Stage:           "device_status",
+			CheckInterval:   30,
+			DefaultInterval: 300,
+			MissedIntervals: 3,
+			DeviceTypeIntervals: map[string]int{
+				"powermeter": 60,
+			},
+		},
+		Discovery: DiscoveryConfig{
+			Enabled:       false,
+			FilePath:      filepath.Join(runtimeDir, "discovery", "unknown_devices.json"),
+			Stage:         "discovery",
+			FlushInterval: 10,
+			MaxDevices:    1000,
+			MaxSampleSize: 4096,
+		},
+		Topics: TopicsConfig{
+			Templates: []string{
+				"Rubicon/mqtt/{customer}/#",
+			},
+			CustomerAliases: map[string]string{},
+		},
+		Kafka: KafkaConfig{
+			ProductionEnvironment:   "production",
+			TopicTemplate:           "rubicon_kafka_{stage}_{env}",
+			GroupTemplate:           "{stage}-{env}-consumer-group",
+			ProductionTopicTemplate: "rubicon_kafka_{stage}",
+			ProductionGroupTemplate: "{stage}-consumer-group",
+			ConsumerStage:           "mqtt",
+			InfluxDBStage:           "influxdb",
+			KodelabsStage:           "kodelabs",
+		},
+		Tracing: TracingConfig{
+			Enabled:     false,
+			Exporter:    "stdout",
+			FilePath:    filepath.Join(runtimeDir, "traces", "traces.jsonl"),
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			ServiceName: "mqtt-worker",
+			SampleRatio: 1,
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Address: "127.0.0.1:8089",
+			Pprof:   true,
+		},
+		Devices: DevicesConfig{
+			Backend:           "mysql",
+			SQLiteFilePath:    filepath.Join(runtimeDir, "devices", "devices.db"),
+			InventoryFilePath: filepath.Join(runtimeDir, "devices", "inventory.yaml"),
+		},
+		Archive: ArchiveConfig{
+			Enabled:       false,
+			Dir:           filepath.Join(runtimeDir, "archive"),
+			RetentionDays: 30,
+			FlushInterval: 5,
+		},
+		Spool: SpoolConfig{
+			Enabled:        false,
+			Dir:            filepath.Join(runtimeDir, "spool"),
+			MaxSizeMB:      256,
+			SegmentSizeMB:  8,
+			MaxAge:         24,
+			ReplayInterval: 5,
+		},
+		Batch: BatchConfig{
+			Enabled:    false,
+			MaxRecords: 100,
+			MaxWaitMs:  200,
+		},
+		Outputs: OutputsConfig{
+			Codec:  "json",
+			Codecs: map[string]string{},
+		},
 	}
-
-	appConfig = defaultAppConfig
 }
 
 // InitAppConfig initializes the app configuration
@@ -74,13 +171,80 @@ func InitAppConfig(filePath string) (fileExists bool, err error) {
 	return false, nil
 }
 
-// GetAppConfig returns the app configuration
-func GetAppConfig(filePath string) *AppConfig {
-	err := coreutils.LoadYAMLFile(filePath, &appConfig)
+// GetAppConfig loads the app configuration from a file on top of the defaults.
+// Files written by older versions are migrated in memory, unknown keys are rejected.
+func GetAppConfig(filePath string) (*AppConfig, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		appConfig = newDefaultAppConfig()
+		return appConfig, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+
+	cfg, _, err := decodeAppConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", filePath, err)
+	}
+
+	appConfig = cfg
+	return appConfig, nil
+}
+
+// decodeAppConfig migrates raw YAML to the current version and strictly
+// decodes it on top of the defaults. It returns the version the data was written with.
+func decodeAppConfig(data []byte) (*AppConfig, int, error) {
+	var raw map[any]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, 0, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if raw == nil {
+		raw = map[any]any{}
+	}
+
+	version, err := migrate(raw)
 	if err != nil {
-		appConfig = defaultAppConfig
+		return nil, version, err
+	}
+
+	migrated, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, version, err
+	}
+
+	cfg := newDefaultAppConfig()
+
+	// yaml.v2 merges into existing maps, so default maps that the file sets are dropped first
+	clearMaps(reflect.ValueOf(cfg).Elem(), raw)
+
+	if err := yaml.UnmarshalStrict(migrated, cfg); err != nil {
+		return nil, version, err
+	}
+
+	return cfg, version, nil
+}
+
+// clearMaps sets map fields that are present in the raw YAML to nil
+func clearMaps(v reflect.Value, raw map[any]any) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		value, ok := raw[name]
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Map:
+			field.Set(reflect.Zero(field.Type()))
+		case reflect.Struct:
+			if nested, ok := value.(map[any]any); ok {
+				clearMaps(field, nested)
+			}
+		}
 	}
-	return appConfig
 }
 
 // SaveAppConfig saves the app configuration
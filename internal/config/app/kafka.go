@@ -0,0 +1,73 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IsProduction reports whether env is the production environment
+func (c *KafkaConfig) IsProduction(env string) bool {
+	return strings.EqualFold(env, c.ProductionEnvironment)
+}
+
+// Topic returns the Kafka topic of a stage in the given environment
+func (c *KafkaConfig) Topic(stage string, env string) string {
+	if c.IsProduction(env) {
+		return expandKafkaTemplate(c.ProductionTopicTemplate, stage, env)
+	}
+
+	return expandKafkaTemplate(c.TopicTemplate, stage, env)
+}
+
+// Group returns the Kafka consumer group of a stage in the given environment
+func (c *KafkaConfig) Group(stage string, env string) string {
+	if c.IsProduction(env) {
+		return expandKafkaTemplate(c.ProductionGroupTemplate, stage, env)
+	}
+
+	return expandKafkaTemplate(c.GroupTemplate, stage, env)
+}
+
+// expandKafkaTemplate replaces the {stage} and {env} variables of a topic or group template
+func expandKafkaTemplate(template string, stage string, env string) string {
+	return strings.NewReplacer("{stage}", stage, "{env}", strings.ToLower(env)).Replace(template)
+}
+
+// ValidateEnvironment checks that no topic or consumer group of a non-production
+// environment resolves to the production one
+func (c *AppConfig) ValidateEnvironment(env string) error {
+	if strings.TrimSpace(env) == "" {
+		return fmt.Errorf("environment is empty")
+	}
+
+	if c.Kafka.IsProduction(env) {
+		return nil
+	}
+
+	var errs []error
+
+	production := c.Kafka.ProductionEnvironment
+	for _, stage := range c.kafkaStages() {
+		if topic := c.Kafka.Topic(stage, env); topic == c.Kafka.Topic(stage, production) {
+			errs = append(errs, fmt.Errorf("environment %s resolves to production topic %s", env, topic))
+		}
+	}
+
+	if group := c.Kafka.Group(c.Kafka.ConsumerStage, env); group == c.Kafka.Group(c.Kafka.ConsumerStage, production) {
+		errs = append(errs, fmt.Errorf("environment %s resolves to production consumer group %s", env, group))
+	}
+
+	return errors.Join(errs...)
+}
+
+// kafkaStages returns every stage the worker consumes from or produces to
+func (c *AppConfig) kafkaStages() []string {
+	return []string{
+		c.Kafka.ConsumerStage,
+		c.Kafka.InfluxDBStage,
+		c.Kafka.KodelabsStage,
+		c.Liveness.Stage,
+		c.Discovery.Stage,
+	}
+}
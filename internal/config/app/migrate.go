@@ -0,0 +1,121 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CurrentConfigVersion is the app.yaml schema version written by this build
+const CurrentConfigVersion = 2
+
+// migrations upgrade a raw app.yaml document from the version they are keyed by
+// to the next version. Sections that did not exist in the old version do not need
+// a migration, they are filled in from the defaults when the file is decoded.
+var migrations = map[int]func(raw map[any]any) error{
+	// Version 0 files predate config_version
+	0: func(raw map[any]any) error { return nil },
+
+	// Version 2 builds Kafka topics from templates, so the liveness and
+	// discovery topics are replaced by stages
+	1: func(raw map[any]any) error {
+		for _, section := range []string{"liveness", "discovery"} {
+			if err := topicToStage(raw, section); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// topicToStage replaces the topic of a config section with a stage, stripping
+// the rubicon_kafka_ prefix that is now part of the topic template
+func topicToStage(raw map[any]any, section string) error {
+	values, ok := raw[section].(map[any]any)
+	if !ok {
+		return nil
+	}
+
+	topic, ok := values["topic"]
+	if !ok {
+		return nil
+	}
+
+	name, ok := topic.(string)
+	if !ok {
+		return fmt.Errorf("invalid %s.topic: %v", section, topic)
+	}
+
+	values["stage"] = strings.TrimPrefix(name, "rubicon_kafka_")
+	delete(values, "topic")
+
+	return nil
+}
+
+// migrate upgrades a raw app.yaml document to the current version in place and
+// returns the version it was written with
+func migrate(raw map[any]any) (int, error) {
+	version := 0
+	if value, ok := raw["config_version"]; ok {
+		v, ok := value.(int)
+		if !ok {
+			return 0, fmt.Errorf("invalid config_version: %v", value)
+		}
+		version = v
+	}
+
+	if version > CurrentConfigVersion {
+		return version, fmt.Errorf("config_version %d is newer than the supported version %d", version, CurrentConfigVersion)
+	}
+
+	for v := version; v < CurrentConfigVersion; v++ {
+		migration, ok := migrations[v]
+		if !ok {
+			return version, fmt.Errorf("no migration from config_version %d", v)
+		}
+
+		if err := migration(raw); err != nil {
+			return version, fmt.Errorf("error migrating config_version %d to %d: %w", v, v+1, err)
+		}
+	}
+
+	raw["config_version"] = CurrentConfigVersion
+
+	return version, nil
+}
+
+// MigrateAppConfig rewrites an app configuration file written by an older version
+// in the current format, keeping a backup of the original. It returns the path of
+// the backup, or an empty string if the file was already up to date.
+func MigrateAppConfig(filePath string) (backupFilePath string, err error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+
+	cfg, version, err := decodeAppConfig(data)
+	if err != nil {
+		return "", fmt.Errorf("error loading %s: %w", filePath, err)
+	}
+
+	if version == CurrentConfigVersion {
+		return "", nil
+	}
+
+	backupFilePath = fmt.Sprintf("%s.v%d.%s.bak", filePath, version, time.Now().Format("20060102150405"))
+	if err := os.WriteFile(backupFilePath, data, 0o640); err != nil {
+		return "", fmt.Errorf("error writing backup %s: %w", backupFilePath, err)
+	}
+
+	appConfig = cfg
+	if err := SaveAppConfig(filePath, false); err != nil {
+		return "", fmt.Errorf("error saving migrated %s: %w", filePath, err)
+	}
+
+	return backupFilePath, nil
+}
@@ -3,8 +3,23 @@ package app
 // ======================== App ======================== //
 
 type AppConfig struct {
-	Runtime RuntimeConfig `mapstructure:"runtime" yaml:"runtime"`
-	Logging LoggingConfig `mapstructure:"logging" yaml:"logging"`
+	ConfigVersion int             `mapstructure:"config_version" yaml:"config_version"`
+	Runtime       RuntimeConfig   `mapstructure:"runtime" yaml:"runtime"`
+	Logging       LoggingConfig   `mapstructure:"logging" yaml:"logging"`
+	InfluxDB      InfluxDBConfig  `mapstructure:"influxdb" yaml:"influxdb"`
+	Kodelabs      KodelabsConfig  `mapstructure:"kodelabs" yaml:"kodelabs"`
+	Stats         StatsConfig     `mapstructure:"stats" yaml:"stats"`
+	Liveness      LivenessConfig  `mapstructure:"liveness" yaml:"liveness"`
+	Discovery     DiscoveryConfig `mapstructure:"discovery" yaml:"discovery"`
+	Topics        TopicsConfig    `mapstructure:"topics" yaml:"topics"`
+	Kafka         KafkaConfig     `mapstructure:"kafka" yaml:"kafka"`
+	Tracing       TracingConfig   `mapstructure:"tracing" yaml:"tracing"`
+	Admin         AdminConfig     `mapstructure:"admin" yaml:"admin"`
+	Devices       DevicesConfig   `mapstructure:"devices" yaml:"devices"`
+	Archive       ArchiveConfig   `mapstructure:"archive" yaml:"archive"`
+	Spool         SpoolConfig     `mapstructure:"spool" yaml:"spool"`
+	Batch         BatchConfig     `mapstructure:"batch" yaml:"batch"`
+	Outputs       OutputsConfig   `mapstructure:"outputs" yaml:"outputs"`
 }
 
 type RuntimeConfig struct {
@@ -13,6 +28,8 @@ type RuntimeConfig struct {
 	PersistFilePath        string `mapstructure:"persist_file_path" yaml:"persist_file_path"`
 	StopFileFilepath       string `mapstructure:"stop_file_filepath" yaml:"stop_file_filepath"`
 	ConnectionsLogFilePath string `mapstructure:"connections_log_file_path" yaml:"connections_log_file_path"`
+	IgnoredFilePath        string `mapstructure:"ignored_file_path" yaml:"ignored_file_path"`
+	ControlDir             string `mapstructure:"control_dir" yaml:"control_dir"`
 }
 
 type LoggingConfig struct {
@@ -24,3 +41,112 @@ type LoggingConfig struct {
 	Compress   bool   `mapstructure:"compress" yaml:"compress"`
 	AddTime    bool   `mapstructure:"add_time" yaml:"add_time"`
 }
+
+type InfluxDBConfig struct {
+	Enabled       bool              `mapstructure:"enabled" yaml:"enabled"`
+	URL           string            `mapstructure:"url" yaml:"url"`
+	Org           string            `mapstructure:"org" yaml:"org"`
+	Bucket        string            `mapstructure:"bucket" yaml:"bucket"`
+	Token         string            `mapstructure:"token" yaml:"token" secret:"true"`
+	Precision     string            `mapstructure:"precision" yaml:"precision"`
+	Measurement   string            `mapstructure:"measurement" yaml:"measurement"`
+	Tags          map[string]string `mapstructure:"tags" yaml:"tags"`
+	Fields        map[string]string `mapstructure:"fields" yaml:"fields"`
+	BatchSize     int               `mapstructure:"batch_size" yaml:"batch_size"`
+	FlushInterval int               `mapstructure:"flush_interval" yaml:"flush_interval"`
+	Gzip          bool              `mapstructure:"gzip" yaml:"gzip"`
+	MaxRetries    int               `mapstructure:"max_retries" yaml:"max_retries"`
+	RetryInterval int               `mapstructure:"retry_interval" yaml:"retry_interval"`
+	Timeout       int               `mapstructure:"timeout" yaml:"timeout"`
+}
+
+type KodelabsConfig struct {
+	MappingDir string `mapstructure:"mapping_dir" yaml:"mapping_dir"`
+}
+
+type StatsConfig struct {
+	FlushInterval int `mapstructure:"flush_interval" yaml:"flush_interval"`
+}
+
+type LivenessConfig struct {
+	Enabled             bool           `mapstructure:"enabled" yaml:"enabled"`
+	Stage               string         `mapstructure:"stage" yaml:"stage"`
+	CheckInterval       int            `mapstructure:"check_interval" yaml:"check_interval"`
+	DefaultInterval     int            `mapstructure:"default_interval" yaml:"default_interval"`
+	MissedIntervals     int            `mapstructure:"missed_intervals" yaml:"missed_intervals"`
+	DeviceTypeIntervals map[string]int `mapstructure:"device_type_intervals" yaml:"device_type_intervals"`
+}
+
+type DiscoveryConfig struct {
+	Enabled       bool   `mapstructure:"enabled" yaml:"enabled"`
+	FilePath      string `mapstructure:"file_path" yaml:"file_path"`
+	Stage         string `mapstructure:"stage" yaml:"stage"`
+	FlushInterval int    `mapstructure:"flush_interval" yaml:"flush_interval"`
+	MaxDevices    int    `mapstructure:"max_devices" yaml:"max_devices"`
+	MaxSampleSize int    `mapstructure:"max_sample_size" yaml:"max_sample_size"`
+}
+
+type TopicsConfig struct {
+	Templates       []string          `mapstructure:"templates" yaml:"templates"`
+	CustomerAliases map[string]string `mapstructure:"customer_aliases" yaml:"customer_aliases"`
+}
+
+type KafkaConfig struct {
+	ProductionEnvironment   string `mapstructure:"production_environment" yaml:"production_environment"`
+	TopicTemplate           string `mapstructure:"topic_template" yaml:"topic_template"`
+	GroupTemplate           string `mapstructure:"group_template" yaml:"group_template"`
+	ProductionTopicTemplate string `mapstructure:"production_topic_template" yaml:"production_topic_template"`
+	ProductionGroupTemplate string `mapstructure:"production_group_template" yaml:"production_group_template"`
+	ConsumerStage           string `mapstructure:"consumer_stage" yaml:"consumer_stage"`
+	InfluxDBStage           string `mapstructure:"influxdb_stage" yaml:"influxdb_stage"`
+	KodelabsStage           string `mapstructure:"kodelabs_stage" yaml:"kodelabs_stage"`
+}
+
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled" yaml:"enabled"`
+	Exporter    string  `mapstructure:"exporter" yaml:"exporter"`
+	FilePath    string  `mapstructure:"file_path" yaml:"file_path"`
+	Endpoint    string  `mapstructure:"endpoint" yaml:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure" yaml:"insecure"`
+	ServiceName string  `mapstructure:"service_name" yaml:"service_name"`
+	SampleRatio float64 `mapstructure:"sample_ratio" yaml:"sample_ratio"`
+}
+
+type AdminConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Address string `mapstructure:"address" yaml:"address"`
+	Pprof   bool   `mapstructure:"pprof" yaml:"pprof"`
+}
+
+type DevicesConfig struct {
+	Backend           string `mapstructure:"backend" yaml:"backend"`
+	SQLiteFilePath    string `mapstructure:"sqlite_file_path" yaml:"sqlite_file_path"`
+	InventoryFilePath string `mapstructure:"inventory_file_path" yaml:"inventory_file_path"`
+}
+
+type ArchiveConfig struct {
+	Enabled       bool   `mapstructure:"enabled" yaml:"enabled"`
+	Dir           string `mapstructure:"dir" yaml:"dir"`
+	RetentionDays int    `mapstructure:"retention_days" yaml:"retention_days"`
+	FlushInterval int    `mapstructure:"flush_interval" yaml:"flush_interval"`
+}
+
+type SpoolConfig struct {
+	Enabled        bool   `mapstructure:"enabled" yaml:"enabled"`
+	Dir            string `mapstructure:"dir" yaml:"dir"`
+	MaxSizeMB      int    `mapstructure:"max_size_mb" yaml:"max_size_mb"`
+	SegmentSizeMB  int    `mapstructure:"segment_size_mb" yaml:"segment_size_mb"`
+	MaxAge         int    `mapstructure:"max_age" yaml:"max_age"`
+	ReplayInterval int    `mapstructure:"replay_interval" yaml:"replay_interval"`
+}
+
+type BatchConfig struct {
+	Enabled    bool `mapstructure:"enabled" yaml:"enabled"`
+	MaxRecords int  `mapstructure:"max_records" yaml:"max_records"`
+	MaxWaitMs  int  `mapstructure:"max_wait_ms" yaml:"max_wait_ms"`
+}
+
+type OutputsConfig struct {
+	Codec  string            `mapstructure:"codec" yaml:"codec"`
+	Codecs map[string]string `mapstructure:"codecs" yaml:"codecs"`
+}
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Validate checks the app configuration for values that would only fail at runtime
+func (c *AppConfig) Validate() error {
+	var errs []error
+
+	if _, err := zapcore.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level: invalid log level %q", c.Logging.Level))
+	}
+
+	dirs := []struct{ key, dir string }{
+		{"runtime.tmp_dir", c.Runtime.TmpDir},
+		{"kodelabs.mapping_dir", c.Kodelabs.MappingDir},
+		{"runtime.control_dir", c.Runtime.ControlDir},
+	}
+	for _, d := range dirs {
+		if err := checkWritable(d.dir); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.key, err))
+		}
+	}
+
+	files := []struct{ key, file string }{
+		{"runtime.persist_file_path", c.Runtime.PersistFilePath},
+		{"runtime.stop_file_filepath", c.Runtime.StopFileFilepath},
+		{"runtime.connections_log_file_path", c.Runtime.ConnectionsLogFilePath},
+		{"runtime.ignored_file_path", c.Runtime.IgnoredFilePath},
+		{"logging.file_path", c.Logging.FilePath},
+		{"discovery.file_path", c.Discovery.FilePath},
+		{"tracing.file_path", c.Tracing.FilePath},
+	}
+	for _, f := range files {
+		if f.file == "" {
+			errs = append(errs, fmt.Errorf("%s: path is empty", f.key))
+			continue
+		}
+		if err := checkWritable(filepath.Dir(f.file)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.key, err))
+		}
+	}
+
+	positive := []struct {
+		key   string
+		value int
+	}{
+		{"logging.max_size", c.Logging.MaxSize},
+		{"influxdb.batch_size", c.InfluxDB.BatchSize},
+		{"influxdb.timeout", c.InfluxDB.Timeout},
+		{"liveness.check_interval", c.Liveness.CheckInterval},
+		{"liveness.default_interval", c.Liveness.DefaultInterval},
+		{"liveness.missed_intervals", c.Liveness.MissedIntervals},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be greater than 0, got %d", p.key, p.value))
+		}
+	}
+
+	switch c.InfluxDB.Precision {
+	case "ns", "us", "ms", "s":
+	default:
+		errs = append(errs, fmt.Errorf("influxdb.precision: invalid precision %q", c.InfluxDB.Precision))
+	}
+
+	templates := []struct{ key, template string }{
+		{"kafka.topic_template", c.Kafka.TopicTemplate},
+		{"kafka.group_template", c.Kafka.GroupTemplate},
+		{"kafka.production_topic_template", c.Kafka.ProductionTopicTemplate},
+		{"kafka.production_group_template", c.Kafka.ProductionGroupTemplate},
+	}
+	for _, t := range templates {
+		if !strings.Contains(t.template, "{stage}") {
+			errs = append(errs, fmt.Errorf("%s: missing {stage} variable in %q", t.key, t.template))
+		}
+	}
+
+	stages := []struct{ key, stage string }{
+		{"kafka.consumer_stage", c.Kafka.ConsumerStage},
+		{"kafka.influxdb_stage", c.Kafka.InfluxDBStage},
+		{"kafka.kodelabs_stage", c.Kafka.KodelabsStage},
+		{"liveness.stage", c.Liveness.Stage},
+		{"discovery.stage", c.Discovery.Stage},
+	}
+	for _, s := range stages {
+		if s.stage == "" {
+			errs = append(errs, fmt.Errorf("%s: stage is empty", s.key))
+		}
+	}
+
+	if c.Kafka.ProductionEnvironment == "" {
+		errs = append(errs, fmt.Errorf("kafka.production_environment: environment is empty"))
+	}
+
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case "stdout", "file":
+		case "otlp":
+			if _, _, err := net.SplitHostPort(c.Tracing.Endpoint); err != nil {
+				errs = append(errs, fmt.Errorf("tracing.endpoint: %w", err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("tracing.exporter: unknown exporter %q", c.Tracing.Exporter))
+		}
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio: must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+
+	if c.Archive.Enabled {
+		if err := checkWritable(c.Archive.Dir); err != nil {
+			errs = append(errs, fmt.Errorf("archive.dir: %w", err))
+		}
+		if c.Archive.FlushInterval <= 0 {
+			errs = append(errs, fmt.Errorf("archive.flush_interval: must be greater than 0, got %d", c.Archive.FlushInterval))
+		}
+		if c.Archive.RetentionDays < 0 {
+			errs = append(errs, fmt.Errorf("archive.retention_days: must be 0 or more, got %d", c.Archive.RetentionDays))
+		}
+	}
+
+	if c.Discovery.Enabled {
+		if c.Discovery.MaxDevices <= 0 {
+			errs = append(errs, fmt.Errorf("discovery.max_devices: must be greater than 0, got %d", c.Discovery.MaxDevices))
+		}
+		if c.Discovery.MaxSampleSize <= 0 {
+			errs = append(errs, fmt.Errorf("discovery.max_sample_size: must be greater than 0, got %d", c.Discovery.MaxSampleSize))
+		}
+	}
+
+	if c.Spool.Enabled {
+		if err := checkWritable(c.Spool.Dir); err != nil {
+			errs = append(errs, fmt.Errorf("spool.dir: %w", err))
+		}
+		for _, p := range []struct {
+			key   string
+			value int
+		}{
+			{"spool.max_size_mb", c.Spool.MaxSizeMB},
+			{"spool.segment_size_mb", c.Spool.SegmentSizeMB},
+			{"spool.replay_interval", c.Spool.ReplayInterval},
+		} {
+			if p.value <= 0 {
+				errs = append(errs, fmt.Errorf("%s: must be greater than 0, got %d", p.key, p.value))
+			}
+		}
+		if c.Spool.SegmentSizeMB > c.Spool.MaxSizeMB {
+			errs = append(errs, fmt.Errorf("spool.segment_size_mb: must not be greater than spool.max_size_mb (%d), got %d", c.Spool.MaxSizeMB, c.Spool.SegmentSizeMB))
+		}
+		if c.Spool.MaxAge < 0 {
+			errs = append(errs, fmt.Errorf("spool.max_age: must be 0 or more, got %d", c.Spool.MaxAge))
+		}
+	}
+
+	if c.Batch.Enabled {
+		if c.Batch.MaxRecords <= 0 {
+			errs = append(errs, fmt.Errorf("batch.max_records: must be greater than 0, got %d", c.Batch.MaxRecords))
+		}
+		if c.Batch.MaxWaitMs <= 0 {
+			errs = append(errs, fmt.Errorf("batch.max_wait_ms: must be greater than 0, got %d", c.Batch.MaxWaitMs))
+		}
+	}
+
+	if !validCodec(c.Outputs.Codec) {
+		errs = append(errs, fmt.Errorf("outputs.codec: unknown codec %q", c.Outputs.Codec))
+	}
+	for stage, codec := range c.Outputs.Codecs {
+		if !validCodec(codec) {
+			errs = append(errs, fmt.Errorf("outputs.codecs.%s: unknown codec %q", stage, codec))
+		}
+	}
+
+	switch c.Devices.Backend {
+	case "mysql":
+	case "sqlite":
+		if err := checkWritable(filepath.Dir(c.Devices.SQLiteFilePath)); err != nil {
+			errs = append(errs, fmt.Errorf("devices.sqlite_file_path: %w", err))
+		}
+	case "inventory":
+		if _, err := os.Stat(c.Devices.InventoryFilePath); err != nil {
+			errs = append(errs, fmt.Errorf("devices.inventory_file_path: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("devices.backend: unknown backend %q", c.Devices.Backend))
+	}
+
+	if c.Admin.Enabled {
+		if _, _, err := net.SplitHostPort(c.Admin.Address); err != nil {
+			errs = append(errs, fmt.Errorf("admin.address: %w", err))
+		}
+	}
+
+	if len(c.Topics.Templates) == 0 {
+		errs = append(errs, fmt.Errorf("topics.templates: no topic templates configured"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validCodec reports whether name is an output codec
+func validCodec(name string) bool {
+	switch name {
+	case "json", "msgpack", "protobuf":
+		return true
+	default:
+		return false
+	}
+}
+
+// checkWritable checks that files can be created in a directory. Directories
+// that do not exist yet are created at startup, so the nearest existing parent
+// is checked instead.
+func checkWritable(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("path is empty")
+	}
+
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("%s does not exist", dir)
+		}
+		dir = parent
+	}
+
+	if !dirWritable(dir) {
+		return fmt.Errorf("%s is not writable", dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package app
+
+import "golang.org/x/sys/unix"
+
+// dirWritable reports whether files can be created in dir. It is checked with
+// access(2) so that validating never writes to the directory.
+func dirWritable(dir string) bool {
+	return unix.Access(dir, unix.W_OK|unix.X_OK) == nil
+}
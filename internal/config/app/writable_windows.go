@@ -0,0 +1,17 @@
+package app
+
+import "os"
+
+// dirWritable reports whether files can be created in dir. Windows has no
+// access(2) that honours ACLs, so a temporary file is created and removed.
+func dirWritable(dir string) bool {
+	file, err := os.CreateTemp(dir, ".writable-*")
+	if err != nil {
+		return false
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+
+	return true
+}
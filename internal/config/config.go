@@ -1,10 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
 	"github.com/johandrevandeventer/mqtt-worker/internal/config/system"
@@ -21,6 +24,9 @@ var (
 type Config struct {
 	System *system.SystemConfig `mapstructure:"system" yaml:"system"`
 	App    *app.AppConfig       `mapstructure:"app" yaml:"app"`
+
+	// Sources records where every app config value came from, keyed by its dotted yaml path
+	Sources map[string]string `mapstructure:"-" yaml:"-"`
 }
 
 // InitConfig initializes the system and application configuration files
@@ -54,12 +60,87 @@ func InitConfig() (newFiles []string, existingFiles []string, err error) {
 	return newFiles, existingFiles, nil
 }
 
-// GetConfig returns the application configuration
-func GetConfig() *Config {
+// MigrateConfig upgrades an app configuration file written by an older version
+// and returns the path of the backup of the original file, if any
+func MigrateConfig() (backupFilePath string, err error) {
+	return app.MigrateAppConfig(appConfigFilePath)
+}
+
+// GetConfig returns the application configuration. App config values are
+// layered: defaults, then app.yaml, then MQTT_WORKER_* environment variables,
+// then --set flags. The result is validated before it is returned.
+func GetConfig() (*Config, error) {
+	appCfg, err := app.GetAppConfig(appConfigFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := applyLayers(appCfg, appConfigFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error applying configuration overrides: %w", err)
+	}
+
+	if err := errors.Join(appCfg.Validate(), appCfg.ValidateEnvironment(flags.FlagEnvironment)); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	return &Config{
-		System: system.GetSystemConfig(systemConfigFilePath),
-		App:    app.GetAppConfig(appConfigFilePath),
+		System:  system.GetSystemConfig(systemConfigFilePath),
+		App:     appCfg,
+		Sources: sources,
+	}, nil
+}
+
+// PrintEffectiveConfig prints every app config value and where it came from,
+// with secret values masked
+func (c *Config) PrintEffectiveConfig(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, value := range c.EffectiveConfig(false) {
+		source := value.Source
+		if source == SourceEnv {
+			source = fmt.Sprintf("%s (%s)", SourceEnv, EnvVarName(value.Key))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", value.Key, value.Value, source)
 	}
+
+	return tw.Flush()
+}
+
+// InitConfigDir writes the default system and app configuration files to the
+// config directory of the given runtime directory. Existing files are only
+// overwritten when force is set.
+func InitConfigDir(runtimeDir string, force bool) (files []string, err error) {
+	runtimeDir, err = filepath.Abs(runtimeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	configDir := filepath.Join(runtimeDir, "config")
+	toWrite := []struct {
+		filePath string
+		cfg      any
+	}{
+		{filepath.Join(configDir, "system.yaml"), system.DefaultSystemConfig()},
+		{filepath.Join(configDir, "app.yaml"), app.DefaultAppConfig(runtimeDir)},
+	}
+
+	if !force {
+		for _, f := range toWrite {
+			if coreutils.FileExists(f.filePath) {
+				return nil, fmt.Errorf("configuration file already exists: %s (use --force to overwrite)", f.filePath)
+			}
+		}
+	}
+
+	for _, f := range toWrite {
+		if err := coreutils.SaveYAMLFile(f.filePath, f.cfg, true); err != nil {
+			return files, err
+		}
+		files = append(files, f.filePath)
+	}
+
+	return files, nil
 }
 
 // SaveConfig saves the configuration
@@ -74,7 +155,7 @@ func SaveConfig() error {
 
 // PrintInfo prints the application information
 func PrintInfo(versionOnly bool) {
-	systemCfg := GetConfig().System
+	systemCfg := system.GetSystemConfig(systemConfigFilePath)
 
 	goVersion := strings.Replace(runtime.Version(), "go", "", 1)
 
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of environment variables that override app config values,
+// e.g. MQTT_WORKER_LOGGING_LEVEL overrides logging.level
+const EnvPrefix = "MQTT_WORKER_"
+
+// Configuration value sources, in increasing order of precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// MaskedValue replaces secret values when the config is printed
+const MaskedValue = "********"
+
+// ConfigValue is a single effective configuration value and where it came from
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// configField is a settable leaf field of the app config. Fields tagged
+// secret:"true" are masked when printed.
+type configField struct {
+	key    string
+	value  reflect.Value
+	secret bool
+}
+
+// EnvVarName returns the environment variable that overrides the given key
+func EnvVarName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// collectFields walks a config struct and returns its leaf fields keyed by
+// their dotted yaml path
+func collectFields(prefix string, v reflect.Value) []configField {
+	var fields []configField
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		fieldValue := v.Field(i)
+		if fieldValue.Kind() == reflect.Struct {
+			fields = append(fields, collectFields(key, fieldValue)...)
+			continue
+		}
+
+		fields = append(fields, configField{
+			key:    key,
+			value:  fieldValue,
+			secret: t.Field(i).Tag.Get("secret") == "true",
+		})
+	}
+
+	return fields
+}
+
+// applyLayers applies environment variable and flag overrides on top of the
+// loaded config and returns the source of every value
+func applyLayers(cfg any, filePath string) (map[string]string, error) {
+	fields := collectFields("", reflect.ValueOf(cfg).Elem())
+	fileKeys := loadFileKeys(filePath)
+
+	sources := make(map[string]string, len(fields))
+	fieldsByKey := make(map[string]configField, len(fields))
+	for _, field := range fields {
+		fieldsByKey[field.key] = field
+
+		if hasKey(fileKeys, field.key) {
+			sources[field.key] = SourceFile
+		} else {
+			sources[field.key] = SourceDefault
+		}
+	}
+
+	var errs []error
+
+	// Environment variables override the config file
+	for _, field := range fields {
+		envVar := EnvVarName(field.key)
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			continue
+		}
+
+		if err := setFieldValue(field.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for %s: %w", envVar, err))
+			continue
+		}
+		sources[field.key] = SourceEnv
+	}
+
+	// Flags override everything
+	for _, override := range flags.FlagConfigOverrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid override %q: expected key=value", override))
+			continue
+		}
+
+		field, ok := fieldsByKey[strings.TrimSpace(key)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid override %q: unknown key %s", override, key))
+			continue
+		}
+
+		if err := setFieldValue(field.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for --set %s: %w", field.key, err))
+			continue
+		}
+		sources[field.key] = SourceFlag
+	}
+
+	return sources, errors.Join(errs...)
+}
+
+// loadFileKeys reads the raw YAML file so that values set in the file can be
+// told apart from defaults
+func loadFileKeys(filePath string) map[any]any {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+
+	var raw map[any]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+
+	return raw
+}
+
+// hasKey reports whether a dotted key is present in a raw YAML map
+func hasKey(raw map[any]any, key string) bool {
+	current := raw
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		value, ok := current[part]
+		if !ok {
+			return false
+		}
+
+		if i == len(parts)-1 {
+			return true
+		}
+
+		current, ok = value.(map[any]any)
+		if !ok {
+			return false
+		}
+	}
+
+	return false
+}
+
+// setFieldValue parses a string into a config field.
+// Lists are comma separated, maps are comma separated key=value pairs.
+func setFieldValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range splitList(value) {
+			element := reflect.New(v.Type().Elem()).Elem()
+			if err := setFieldValue(element, item); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, element)
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, item := range splitList(value) {
+			k, val, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+
+			element := reflect.New(v.Type().Elem()).Elem()
+			if err := setFieldValue(element, val); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), element)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// formatFieldValue formats a config field the way it would be set from the environment
+func formatFieldValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items[i] = formatFieldValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		items := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			items = append(items, fmt.Sprintf("%v=%s", k.Interface(), formatFieldValue(v.MapIndex(k))))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// EffectiveConfig returns every app config value with the source it came from.
+// Secret values are masked unless showSecrets is set.
+func (c *Config) EffectiveConfig(showSecrets bool) []ConfigValue {
+	fields := collectFields("", reflect.ValueOf(c.App).Elem())
+
+	values := make([]ConfigValue, 0, len(fields))
+	for _, field := range fields {
+		source := c.Sources[field.key]
+		if source == "" {
+			source = SourceDefault
+		}
+
+		value := formatFieldValue(field.value)
+		if field.secret && value != "" && !showSecrets {
+			value = MaskedValue
+		}
+
+		values = append(values, ConfigValue{
+			Key:    field.key,
+			Value:  value,
+			Source: source,
+			Secret: field.secret,
+		})
+	}
+
+	return values
+}
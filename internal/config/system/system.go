@@ -15,14 +15,19 @@ var (
 )
 
 func init() {
-	defaultSystemConfig = &SystemConfig{
+	defaultSystemConfig = DefaultSystemConfig()
+
+	systemConfig = defaultSystemConfig
+}
+
+// DefaultSystemConfig returns a fresh copy of the default system configuration
+func DefaultSystemConfig() *SystemConfig {
+	return &SystemConfig{
 		AppName:      "Your App Name",
 		AppVersion:   "0.1.0",
 		ReleaseDate:  "2025-01-01",
 		Contributors: []string{"Your name"},
 	}
-
-	systemConfig = defaultSystemConfig
 }
 
 // InitSystemConfig initializes the system configuration
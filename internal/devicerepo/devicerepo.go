@@ -0,0 +1,35 @@
+package devicerepo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	"go.uber.org/zap"
+)
+
+// Device repository backends
+const (
+	BackendMySQL     = "mysql"
+	BackendSQLite    = "sqlite"
+	BackendInventory = "inventory"
+)
+
+// New creates the device repository selected in the configuration
+func New(cfg *app.DevicesConfig, logger *zap.Logger) (workers.DeviceRepository, error) {
+	switch cfg.Backend {
+	case BackendMySQL:
+		return NewMySQL(), nil
+	case BackendSQLite:
+		if err := os.MkdirAll(filepath.Dir(cfg.SQLiteFilePath), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create SQLite directory: %w", err)
+		}
+		return NewSQLite(cfg.SQLiteFilePath)
+	case BackendInventory:
+		return NewInventory(cfg.InventoryFilePath, logger)
+	default:
+		return nil, fmt.Errorf("unknown device repository backend: %s", cfg.Backend)
+	}
+}
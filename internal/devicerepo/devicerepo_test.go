@@ -0,0 +1,317 @@
+package devicerepo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	acme   = models.Customer{ID: uuid.MustParse("6f1c2a44-0d5e-4c4b-9a63-1a2b3c4d5e01"), Name: "Acme"}
+	globex = models.Customer{ID: uuid.MustParse("6f1c2a44-0d5e-4c4b-9a63-1a2b3c4d5e02"), Name: "Globex"}
+
+	acmeHeadOffice = models.Site{ID: uuid.MustParse("0b7e9c1d-8a2f-4e3b-bf51-2c3d4e5f6a01"), Name: "Acme Head Office", CustomerID: acme.ID, Customer: acme}
+	globexPlant    = models.Site{ID: uuid.MustParse("0b7e9c1d-8a2f-4e3b-bf51-2c3d4e5f6a02"), Name: "Globex Plant", CustomerID: globex.ID, Customer: globex}
+
+	// testDevices are two meters on an Acme controller and one on a Globex controller
+	testDevices = []models.Device{
+		testDevice("CW-1000", "CW-1001", acmeHeadOffice),
+		testDevice("CW-1000", "CW-1002", acmeHeadOffice),
+		testDevice("CW-2000", "CW-2001", globexPlant),
+	}
+)
+
+func testDevice(controllerIdentifier, deviceIdentifier string, site models.Site) models.Device {
+	return models.Device{
+		ID:                   uuid.NewSHA1(uuid.NameSpaceOID, []byte(deviceIdentifier)),
+		Controller:           "CloudWatch",
+		ControllerIdentifier: controllerIdentifier,
+		DeviceType:           "PowerMeter",
+		DeviceIdentifier:     deviceIdentifier,
+		DeviceName:           "Meter " + deviceIdentifier,
+		SiteID:               site.ID,
+		Site:                 site,
+	}
+}
+
+// newSQLite creates a SQLite repository holding testDevices
+func newSQLite(t *testing.T) workers.DeviceRepository {
+	t.Helper()
+
+	repo, err := NewSQLite(filepath.Join(t.TempDir(), "devices.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := repo.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skip the hooks that would replace the IDs with random ones
+	tx := db.Session(&gorm.Session{SkipHooks: true})
+	for _, customer := range []models.Customer{acme, globex} {
+		if err := tx.Create(&customer).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, site := range []models.Site{acmeHeadOffice, globexPlant} {
+		if err := tx.Omit("Customer").Create(&site).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, device := range testDevices {
+		if err := tx.Omit("Site").Create(&device).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return repo
+}
+
+// newInventory creates an inventory repository holding testDevices
+func newInventory(t *testing.T) workers.DeviceRepository {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "inventory.yaml")
+	writeInventory(t, filePath, testDevices)
+
+	inventory, err := NewInventory(filePath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return inventory
+}
+
+// writeInventory writes devices to an inventory file
+func writeInventory(t *testing.T, filePath string, devices []models.Device) {
+	t.Helper()
+
+	var b strings.Builder
+	b.WriteString("devices:\n")
+	for _, d := range devices {
+		fmt.Fprintf(&b, "  - id: %s\n", d.ID)
+		fmt.Fprintf(&b, "    controller: %s\n", d.Controller)
+		fmt.Fprintf(&b, "    controller_identifier: %s\n", d.ControllerIdentifier)
+		fmt.Fprintf(&b, "    device_type: %s\n", d.DeviceType)
+		fmt.Fprintf(&b, "    device_identifier: %s\n", d.DeviceIdentifier)
+		fmt.Fprintf(&b, "    device_name: %s\n", d.DeviceName)
+		fmt.Fprintf(&b, "    site:\n")
+		fmt.Fprintf(&b, "      id: %s\n", d.Site.ID)
+		fmt.Fprintf(&b, "      name: %s\n", d.Site.Name)
+		fmt.Fprintf(&b, "      customer:\n")
+		fmt.Fprintf(&b, "        id: %s\n", d.Site.Customer.ID)
+		fmt.Fprintf(&b, "        name: %s\n", d.Site.Customer.Name)
+	}
+
+	writeFile(t, filePath, b.String())
+}
+
+// writeFile writes a file with a modification time after the previous one, so
+// that the change is seen on file systems with coarse timestamps
+func writeFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	modTime := time.Now()
+	if info, err := os.Stat(filePath); err == nil && !modTime.After(info.ModTime()) {
+		modTime = info.ModTime().Add(time.Second)
+	}
+
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var backends = []struct {
+	name string
+	new  func(t *testing.T) workers.DeviceRepository
+}{
+	{"memory", func(t *testing.T) workers.DeviceRepository { return NewMemory(testDevices) }},
+	{"sqlite", newSQLite},
+	{"inventory", newInventory},
+}
+
+func TestGetDeviceByDeviceIdentifier(t *testing.T) {
+	tests := []struct {
+		deviceIdentifier string
+		site             string
+		customer         string
+		err              error
+	}{
+		{deviceIdentifier: "CW-1001", site: "Acme Head Office", customer: "Acme"},
+		{deviceIdentifier: "CW-2001", site: "Globex Plant", customer: "Globex"},
+		{deviceIdentifier: "CW-9999", err: workers.ErrRecordNotFound},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			repo := backend.new(t)
+
+			for _, tt := range tests {
+				device, err := repo.GetDeviceByDeviceIdentifier(tt.deviceIdentifier)
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Errorf("%s: got error %v, want %v", tt.deviceIdentifier, err, tt.err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s: %v", tt.deviceIdentifier, err)
+					continue
+				}
+
+				want := uuid.NewSHA1(uuid.NameSpaceOID, []byte(tt.deviceIdentifier))
+				if device.ID != want || device.DeviceIdentifier != tt.deviceIdentifier || device.Site.Name != tt.site || device.Site.Customer.Name != tt.customer {
+					t.Errorf("%s: got device %s (%s) at %q of %q, want %s at %q of %q", tt.deviceIdentifier, device.DeviceIdentifier, device.ID, device.Site.Name, device.Site.Customer.Name, want, tt.site, tt.customer)
+				}
+			}
+		})
+	}
+}
+
+func TestGetDevicesByControllerIdentifier(t *testing.T) {
+	tests := []struct {
+		controllerIdentifier string
+		devices              []string
+	}{
+		{controllerIdentifier: "CW-1000", devices: []string{"CW-1001", "CW-1002"}},
+		{controllerIdentifier: "CW-2000", devices: []string{"CW-2001"}},
+		{controllerIdentifier: "CW-9000"},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			repo := backend.new(t)
+
+			for _, tt := range tests {
+				devices, err := repo.GetDevicesByControllerIdentifier(tt.controllerIdentifier)
+				if err != nil {
+					t.Errorf("%s: %v", tt.controllerIdentifier, err)
+					continue
+				}
+
+				var got []string
+				for _, device := range devices {
+					got = append(got, device.DeviceIdentifier)
+					if device.Site.Customer.Name == "" {
+						t.Errorf("%s: device %s has no customer", tt.controllerIdentifier, device.DeviceIdentifier)
+					}
+				}
+				sort.Strings(got)
+
+				if fmt.Sprint(got) != fmt.Sprint(tt.devices) {
+					t.Errorf("%s: got devices %v, want %v", tt.controllerIdentifier, got, tt.devices)
+				}
+			}
+		})
+	}
+}
+
+func TestInventoryReload(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "inventory.yaml")
+	writeInventory(t, filePath, testDevices[:1])
+
+	inventory, err := NewInventory(filePath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := inventory.GetDeviceByDeviceIdentifier("CW-2001"); !errors.Is(err, workers.ErrRecordNotFound) {
+		t.Fatalf("got error %v before the device was added, want %v", err, workers.ErrRecordNotFound)
+	}
+
+	// A changed file is read on the next lookup
+	writeInventory(t, filePath, testDevices)
+	if _, err := inventory.GetDeviceByDeviceIdentifier("CW-2001"); err != nil {
+		t.Fatalf("device added to the inventory not found: %v", err)
+	}
+
+	// An invalid file keeps the previous inventory
+	writeFile(t, filePath, "devices:\n  - device_identifier: CW-3001\n    colour: red\n")
+	devices, err := inventory.GetAllDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != len(testDevices) {
+		t.Errorf("got %d devices after an invalid reload, want the previous %d", len(devices), len(testDevices))
+	}
+}
+
+func TestInventoryInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		ext     string
+		content string
+		err     string
+	}{
+		{
+			name:    "unknown field",
+			ext:     ".yaml",
+			content: "devices:\n  - device_identifier: CW-1001\n    colour: red\n",
+			err:     "field colour not found",
+		},
+		{
+			name:    "unknown JSON field",
+			ext:     ".json",
+			content: `{"devices": [{"device_identifier": "CW-1001", "colour": "red"}]}`,
+			err:     `unknown field "colour"`,
+		},
+		{
+			name:    "missing device identifier",
+			ext:     ".yaml",
+			content: "devices:\n  - device_name: Meter\n",
+			err:     "device 1: device_identifier is empty",
+		},
+		{
+			name:    "duplicate device identifier",
+			ext:     ".yaml",
+			content: "devices:\n  - device_identifier: CW-1001\n    site: {name: Head Office, customer: {name: Acme}}\n  - device_identifier: CW-1001\n    site: {name: Head Office, customer: {name: Acme}}\n",
+			err:     "device CW-1001: duplicate device_identifier",
+		},
+		{
+			name:    "missing customer",
+			ext:     ".yaml",
+			content: "devices:\n  - device_identifier: CW-1001\n    site: {name: Head Office}\n",
+			err:     "device CW-1001: site.customer.name is empty",
+		},
+		{
+			name:    "invalid ID",
+			ext:     ".yaml",
+			content: "devices:\n  - id: meter-1\n    device_identifier: CW-1001\n    site: {name: Head Office, customer: {name: Acme}}\n",
+			err:     "device CW-1001: id:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "inventory"+tt.ext)
+			writeFile(t, filePath, tt.content)
+
+			_, err := NewInventory(filePath, zap.NewNop())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := NewInventory(filepath.Join(t.TempDir(), "inventory.yaml"), zap.NewNop()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package devicerepo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"github.com/johandrevandeventer/devicesdb"
+	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// GormRepository is a DeviceRepository backed by a GORM database
+type GormRepository struct {
+	db func() (*gorm.DB, error)
+}
+
+// NewMySQL creates a repository backed by the devices database. The connection
+// is made on first use from the DB_URL environment variable and retried on the
+// next lookup if it fails.
+func NewMySQL() *GormRepository {
+	return &GormRepository{
+		db: func() (*gorm.DB, error) {
+			bmsDB, err := devicesdb.GetDB()
+			if err != nil {
+				return nil, err
+			}
+			return bmsDB.DB, nil
+		},
+	}
+}
+
+// NewSQLite creates a repository backed by a SQLite file, creating the file and
+// the customers, sites and devices tables if they do not exist
+func NewSQLite(filePath string) (*GormRepository, error) {
+	db, err := gorm.Open(sqlite.Open(filePath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database %s: %w", filePath, err)
+	}
+
+	if err := db.AutoMigrate(&models.Customer{}, &models.Site{}, &models.Device{}); err != nil {
+		return nil, fmt.Errorf("failed to create SQLite tables: %w", err)
+	}
+
+	return &GormRepository{
+		db: func() (*gorm.DB, error) {
+			return db, nil
+		},
+	}, nil
+}
+
+func (r *GormRepository) GetAllCustomers() ([]models.Customer, error) {
+	db, err := r.db()
+	if err != nil {
+		return nil, err
+	}
+
+	var customers []models.Customer
+	if err := db.Find(&customers).Error; err != nil {
+		return nil, fmt.Errorf("failed to get customers: %w", err)
+	}
+
+	return customers, nil
+}
+
+func (r *GormRepository) GetAllDevices() ([]models.Device, error) {
+	db, err := r.db()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []models.Device
+	if err := db.Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+func (r *GormRepository) GetDevicesByControllerIdentifier(controllerIdentifier string) ([]models.Device, error) {
+	db, err := r.db()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []models.Device
+	if err := db.Preload("Site.Customer").Where("controller_identifier = ?", controllerIdentifier).Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+func (r *GormRepository) GetDeviceByDeviceIdentifier(deviceIdentifier string) (models.Device, error) {
+	db, err := r.db()
+	if err != nil {
+		return models.Device{}, err
+	}
+
+	var device models.Device
+	if err := db.Preload("Site.Customer").Where("device_identifier = ?", deviceIdentifier).First(&device).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = workers.ErrRecordNotFound
+		}
+		return models.Device{}, fmt.Errorf("failed to get device: %w", err)
+	}
+
+	return device, nil
+}
@@ -0,0 +1,235 @@
+package devicerepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/devicesdb/models"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// inventoryFile is a local device inventory. Devices carry their site and the
+// site's customer, like models.Device.
+type inventoryFile struct {
+	Devices []inventoryDevice `yaml:"devices" json:"devices"`
+}
+
+type inventoryDevice struct {
+	ID                   string        `yaml:"id" json:"id"`
+	Gateway              string        `yaml:"gateway" json:"gateway"`
+	Controller           string        `yaml:"controller" json:"controller"`
+	ControllerIdentifier string        `yaml:"controller_identifier" json:"controller_identifier"`
+	DeviceType           string        `yaml:"device_type" json:"device_type"`
+	DeviceIdentifier     string        `yaml:"device_identifier" json:"device_identifier"`
+	DeviceName           string        `yaml:"device_name" json:"device_name"`
+	BuildingURL          string        `yaml:"building_url" json:"building_url"`
+	AuthToken            string        `yaml:"auth_token" json:"auth_token"`
+	Site                 inventorySite `yaml:"site" json:"site"`
+}
+
+type inventorySite struct {
+	ID       string            `yaml:"id" json:"id"`
+	Name     string            `yaml:"name" json:"name"`
+	Customer inventoryCustomer `yaml:"customer" json:"customer"`
+}
+
+type inventoryCustomer struct {
+	ID   string `yaml:"id" json:"id"`
+	Name string `yaml:"name" json:"name"`
+}
+
+// Inventory is a DeviceRepository read from a local YAML or JSON file. The file
+// is reloaded when it changes; if the new file is invalid the previous
+// inventory is kept.
+type Inventory struct {
+	*Memory
+
+	mu       sync.Mutex
+	filePath string
+	modTime  time.Time
+	lastErr  string
+	logger   *zap.Logger
+}
+
+// NewInventory creates a repository from an inventory file
+func NewInventory(filePath string, logger *zap.Logger) (*Inventory, error) {
+	inventory := &Inventory{
+		Memory:   NewMemory(nil),
+		filePath: filePath,
+		logger:   logger,
+	}
+
+	if err := inventory.reload(); err != nil {
+		return nil, err
+	}
+
+	return inventory, nil
+}
+
+// refresh reloads the inventory file if it changed since it was last read
+func (i *Inventory) refresh() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	info, err := os.Stat(i.filePath)
+	if err == nil && info.ModTime().Equal(i.modTime) {
+		return
+	}
+
+	if err == nil {
+		err = i.load(info.ModTime())
+		if err != nil {
+			// Do not parse the invalid file again until it changes
+			i.modTime = info.ModTime()
+		}
+	}
+
+	if err != nil {
+		// Log each failure once instead of on every lookup
+		if err.Error() != i.lastErr {
+			i.lastErr = err.Error()
+			i.logger.Error("Failed to reload device inventory, using the previous inventory", zap.String("path", i.filePath), zap.Error(err))
+		}
+		return
+	}
+
+	i.lastErr = ""
+	i.logger.Info("Device inventory reloaded", zap.String("path", i.filePath))
+}
+
+// reload reads the inventory file
+func (i *Inventory) reload() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	info, err := os.Stat(i.filePath)
+	if err != nil {
+		return fmt.Errorf("error opening device inventory: %w", err)
+	}
+
+	return i.load(info.ModTime())
+}
+
+// load parses the inventory file and replaces the devices
+func (i *Inventory) load(modTime time.Time) error {
+	data, err := os.ReadFile(i.filePath)
+	if err != nil {
+		return fmt.Errorf("error opening device inventory: %w", err)
+	}
+
+	var file inventoryFile
+	if strings.EqualFold(filepath.Ext(i.filePath), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		err = yaml.UnmarshalStrict(data, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding device inventory %s: %w", i.filePath, err)
+	}
+
+	devices, err := file.toModels()
+	if err != nil {
+		return fmt.Errorf("invalid device inventory %s: %w", i.filePath, err)
+	}
+
+	i.SetDevices(devices)
+	i.modTime = modTime
+
+	return nil
+}
+
+// toModels converts the inventory to devices. Missing IDs are derived from
+// the names, so that they stay the same across reloads.
+func (f *inventoryFile) toModels() ([]models.Device, error) {
+	seen := make(map[string]bool)
+	devices := make([]models.Device, 0, len(f.Devices))
+
+	for n, d := range f.Devices {
+		if d.DeviceIdentifier == "" {
+			return nil, fmt.Errorf("device %d: device_identifier is empty", n+1)
+		}
+		if seen[d.DeviceIdentifier] {
+			return nil, fmt.Errorf("device %s: duplicate device_identifier", d.DeviceIdentifier)
+		}
+		seen[d.DeviceIdentifier] = true
+
+		if d.Site.Name == "" {
+			return nil, fmt.Errorf("device %s: site.name is empty", d.DeviceIdentifier)
+		}
+		if d.Site.Customer.Name == "" {
+			return nil, fmt.Errorf("device %s: site.customer.name is empty", d.DeviceIdentifier)
+		}
+
+		customerID, err := inventoryID(d.Site.Customer.ID, "customer", d.Site.Customer.Name)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: site.customer.id: %w", d.DeviceIdentifier, err)
+		}
+
+		siteID, err := inventoryID(d.Site.ID, "site", d.Site.Customer.Name, d.Site.Name)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: site.id: %w", d.DeviceIdentifier, err)
+		}
+
+		deviceID, err := inventoryID(d.ID, "device", d.DeviceIdentifier)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: id: %w", d.DeviceIdentifier, err)
+		}
+
+		customer := models.Customer{ID: customerID, Name: d.Site.Customer.Name}
+		devices = append(devices, models.Device{
+			ID:                   deviceID,
+			Gateway:              d.Gateway,
+			Controller:           d.Controller,
+			ControllerIdentifier: d.ControllerIdentifier,
+			DeviceType:           d.DeviceType,
+			DeviceIdentifier:     d.DeviceIdentifier,
+			DeviceName:           d.DeviceName,
+			BuildingURL:          d.BuildingURL,
+			AuthToken:            d.AuthToken,
+			SiteID:               siteID,
+			Site:                 models.Site{ID: siteID, Name: d.Site.Name, CustomerID: customerID, Customer: customer},
+		})
+	}
+
+	return devices, nil
+}
+
+// inventoryID parses an ID from the inventory, or derives one from the names
+// of the record if it is empty
+func inventoryID(id string, kind string, names ...string) (uuid.UUID, error) {
+	if id != "" {
+		return uuid.Parse(id)
+	}
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(kind+"/"+strings.ToLower(strings.Join(names, "/")))), nil
+}
+
+func (i *Inventory) GetAllCustomers() ([]models.Customer, error) {
+	i.refresh()
+	return i.Memory.GetAllCustomers()
+}
+
+func (i *Inventory) GetAllDevices() ([]models.Device, error) {
+	i.refresh()
+	return i.Memory.GetAllDevices()
+}
+
+func (i *Inventory) GetDevicesByControllerIdentifier(controllerIdentifier string) ([]models.Device, error) {
+	i.refresh()
+	return i.Memory.GetDevicesByControllerIdentifier(controllerIdentifier)
+}
+
+func (i *Inventory) GetDeviceByDeviceIdentifier(deviceIdentifier string) (models.Device, error) {
+	i.refresh()
+	return i.Memory.GetDeviceByDeviceIdentifier(deviceIdentifier)
+}
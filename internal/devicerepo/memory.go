@@ -0,0 +1,82 @@
+package devicerepo
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+)
+
+// Memory is a DeviceRepository that holds its devices in memory. Customers are
+// taken from the sites of the devices.
+type Memory struct {
+	mu      sync.RWMutex
+	devices []models.Device
+}
+
+// NewMemory creates an in-memory repository with the given devices
+func NewMemory(devices []models.Device) *Memory {
+	return &Memory{
+		devices: devices,
+	}
+}
+
+// SetDevices replaces the devices in the repository
+func (m *Memory) SetDevices(devices []models.Device) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.devices = devices
+}
+
+func (m *Memory) GetAllCustomers() ([]models.Customer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[uuid.UUID]bool)
+	var customers []models.Customer
+	for _, device := range m.devices {
+		if !seen[device.Site.Customer.ID] {
+			seen[device.Site.Customer.ID] = true
+			customers = append(customers, device.Site.Customer)
+		}
+	}
+
+	return customers, nil
+}
+
+func (m *Memory) GetAllDevices() ([]models.Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]models.Device(nil), m.devices...), nil
+}
+
+func (m *Memory) GetDevicesByControllerIdentifier(controllerIdentifier string) ([]models.Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var devices []models.Device
+	for _, device := range m.devices {
+		if device.ControllerIdentifier == controllerIdentifier {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices, nil
+}
+
+func (m *Memory) GetDeviceByDeviceIdentifier(deviceIdentifier string) (models.Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, device := range m.devices {
+		if device.DeviceIdentifier == deviceIdentifier {
+			return device, nil
+		}
+	}
+
+	return models.Device{}, fmt.Errorf("failed to get device: %w", workers.ErrRecordNotFound)
+}
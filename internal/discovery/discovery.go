@@ -0,0 +1,212 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnknownDevice is a device identifier that was received but is not registered
+type UnknownDevice struct {
+	DeviceIdentifier string          `json:"device_identifier"`
+	Customer         string          `json:"customer"`
+	Topic            string          `json:"topic"`
+	SiteName         string          `json:"site_name"`
+	DeviceName       string          `json:"device_name"`
+	FirstSeen        time.Time       `json:"first_seen"`
+	LastSeen         time.Time       `json:"last_seen"`
+	Count            uint64          `json:"count"`
+	SamplePayload    json.RawMessage `json:"sample_payload"`
+}
+
+// Registry records unknown devices and stores them in a local JSON file. It
+// keeps at most maxDevices devices, evicting the least recently seen, so a
+// publisher sending random identifiers cannot grow it without bound.
+type Registry struct {
+	mu            sync.Mutex
+	filePath      string
+	maxDevices    int
+	maxSampleSize int
+	devices       map[string]*UnknownDevice
+	dirty         bool
+}
+
+// NewRegistry creates a new Registry, loading previously recorded devices from
+// filePath. Sample payloads larger than maxSampleSize bytes are truncated.
+func NewRegistry(filePath string, maxDevices int, maxSampleSize int) (*Registry, error) {
+	devices, err := Load(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		filePath:      filePath,
+		maxDevices:    maxDevices,
+		maxSampleSize: maxSampleSize,
+		devices:       make(map[string]*UnknownDevice, len(devices)),
+	}
+
+	// Devices are sorted most recently seen first, so the oldest are dropped
+	for i := range devices {
+		if maxDevices > 0 && len(r.devices) >= maxDevices {
+			r.dirty = true
+			break
+		}
+		r.devices[devices[i].DeviceIdentifier] = &devices[i]
+	}
+
+	return r, nil
+}
+
+// Record records a sighting of an unknown device. It returns the updated record
+// and whether this is the first time the device was seen.
+func (r *Registry) Record(device UnknownDevice, payload []byte, at time.Time) (UnknownDevice, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dirty = true
+
+	existing, ok := r.devices[device.DeviceIdentifier]
+	if !ok {
+		if r.maxDevices > 0 && len(r.devices) >= r.maxDevices {
+			r.evictOldest()
+		}
+
+		device.FirstSeen = at
+		device.LastSeen = at
+		device.Count = 1
+		device.SamplePayload = samplePayload(payload, r.maxSampleSize)
+		r.devices[device.DeviceIdentifier] = &device
+		return device, true
+	}
+
+	existing.Customer = device.Customer
+	existing.Topic = device.Topic
+	existing.SiteName = device.SiteName
+	existing.DeviceName = device.DeviceName
+	existing.LastSeen = at
+	existing.Count++
+	existing.SamplePayload = samplePayload(payload, r.maxSampleSize)
+
+	return *existing, false
+}
+
+// evictOldest removes the least recently seen device
+func (r *Registry) evictOldest() {
+	var oldest *UnknownDevice
+	for _, device := range r.devices {
+		if oldest == nil || device.LastSeen.Before(oldest.LastSeen) {
+			oldest = device
+		}
+	}
+
+	if oldest != nil {
+		delete(r.devices, oldest.DeviceIdentifier)
+	}
+}
+
+// List returns all recorded devices, most recently seen first
+func (r *Registry) List() []UnknownDevice {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	devices := make([]UnknownDevice, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, *device)
+	}
+
+	sortDevices(devices)
+
+	return devices
+}
+
+// Save writes the recorded devices to the registry file if anything changed
+func (r *Registry) Save() (err error) {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	// Retry on the next save if this one fails
+	defer func() {
+		if err != nil {
+			r.mu.Lock()
+			r.dirty = true
+			r.mu.Unlock()
+		}
+	}()
+
+	devices := r.List()
+
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize unknown devices: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.filePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create discovery directory: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial file
+	tmpFilePath := r.filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write unknown devices: %w", err)
+	}
+
+	if err := os.Rename(tmpFilePath, r.filePath); err != nil {
+		return fmt.Errorf("failed to replace unknown devices file: %w", err)
+	}
+
+	return nil
+}
+
+// Load reads recorded devices from a registry file. A missing file is not an error.
+func Load(filePath string) ([]UnknownDevice, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read unknown devices: %w", err)
+	}
+
+	var devices []UnknownDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("failed to decode unknown devices: %w", err)
+	}
+
+	sortDevices(devices)
+
+	return devices, nil
+}
+
+// sortDevices sorts devices by last seen time, most recent first
+func sortDevices(devices []UnknownDevice) {
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].LastSeen.After(devices[j].LastSeen)
+	})
+}
+
+// samplePayload keeps JSON payloads as-is and stores anything else as a JSON
+// string. Payloads larger than maxSize bytes are cut and stored as a string.
+func samplePayload(payload []byte, maxSize int) json.RawMessage {
+	if maxSize > 0 && len(payload) > maxSize {
+		sample, _ := json.Marshal(strings.ToValidUTF8(string(payload[:maxSize]), "") + "...")
+		return sample
+	}
+
+	if json.Valid(payload) {
+		return append(json.RawMessage(nil), payload...)
+	}
+
+	sample, _ := json.Marshal(string(payload))
+	return sample
+}
@@ -0,0 +1,283 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/ignored"
+	mqttworker "github.com/johandrevandeventer/mqtt-worker/internal/workers/mqtt_worker"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// logLevelRequest is the body of a log level change
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// startAdminServer starts the admin HTTP API if it is enabled. It is stopped
+// together with the engine.
+func (e *Engine) startAdminServer() {
+	if !e.cfg.App.Admin.Enabled {
+		return
+	}
+
+	server := &http.Server{
+		Addr:              e.cfg.App.Admin.Address,
+		Handler:           e.adminHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.logger.Info("Starting admin server", zap.String("address", server.Addr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.logger.Error("Admin server failed", zap.Error(err))
+		}
+	}()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		select {
+		case <-e.ctx.Done():
+		case <-e.stopFileChan:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			e.logger.Error("Failed to stop admin server", zap.Error(err))
+		}
+	}()
+}
+
+// adminHandler returns the routes of the admin HTTP API
+func (e *Engine) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /state", e.handleAdminPersistedState)
+	mux.HandleFunc("GET /state/runtime", e.handleAdminRuntimeState)
+	mux.HandleFunc("GET /workers", e.handleAdminWorkers)
+	mux.HandleFunc("GET /ignore", e.handleAdminListIgnored)
+	mux.HandleFunc("POST /ignore", e.handleAdminAddIgnored)
+	mux.HandleFunc("DELETE /ignore/{id}", e.handleAdminRemoveIgnored)
+	mux.HandleFunc("POST /caches/invalidate", e.handleAdminInvalidateCaches)
+	mux.HandleFunc("GET /loglevels", e.handleAdminLogLevels)
+	mux.HandleFunc("PUT /loglevels", e.handleAdminSetLogLevel)
+	mux.HandleFunc("PUT /loglevels/{name}", e.handleAdminSetLogLevel)
+	mux.HandleFunc("POST /commands/{name}", e.handleAdminCommand)
+
+	if e.cfg.App.Admin.Pprof {
+		mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+		mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	}
+
+	return mux
+}
+
+// handleAdminPersistedState returns the state file written by the persister.
+// The persist file path is not reloadable, so it is read without controlMu.
+func (e *Engine) handleAdminPersistedState(w http.ResponseWriter, r *http.Request) {
+	data, err := os.ReadFile(e.cfg.App.Runtime.PersistFilePath)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, fmt.Errorf("failed to read persisted state: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleAdminRuntimeState returns a snapshot of the engine state
+func (e *Engine) handleAdminRuntimeState(w http.ResponseWriter, r *http.Request) {
+	e.controlMu.Lock()
+	state := e.State()
+	e.controlMu.Unlock()
+
+	writeAdminJSON(w, http.StatusOK, state)
+}
+
+// handleAdminWorkers lists the registered decoders and processors
+func (e *Engine) handleAdminWorkers(w http.ResponseWriter, r *http.Request) {
+	worker := mqttworker.NewWorker(zap.NewNop(), e.topicMatcher.Load(), e.devices)
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"decoders":   worker.Decoders(),
+		"processors": worker.Processors(),
+	})
+}
+
+// handleAdminListIgnored returns the ignore rules
+func (e *Engine) handleAdminListIgnored(w http.ResponseWriter, r *http.Request) {
+	list, err := ignored.Load(e.ignoredFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		list, err = &types.IgnoredControllersAndDevices{}, nil
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, list.Rules)
+}
+
+// handleAdminAddIgnored adds an ignore rule and reloads the ignore list
+func (e *Engine) handleAdminAddIgnored(w http.ResponseWriter, r *http.Request) {
+	var rule types.IgnoreRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid ignore rule: %w", err))
+		return
+	}
+
+	if rule.ID == "" {
+		rule.ID = ignored.NewRuleID()
+	}
+	if rule.Match == "" {
+		rule.Match = types.IgnoreMatchGlob
+	}
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+
+	if err := ignored.Validate(rule); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := ignored.Update(e.ignoredFilePath(), func(list *types.IgnoredControllersAndDevices) error {
+		if slices.ContainsFunc(list.Rules, func(existing types.IgnoreRule) bool { return existing.ID == rule.ID }) {
+			return fmt.Errorf("ignore rule already exists: %s", rule.ID)
+		}
+		list.Rules = append(list.Rules, rule)
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusConflict, err)
+		return
+	}
+
+	e.RunCommand(CommandReloadIgnoreList, "", controlSourceAdmin)
+
+	writeAdminJSON(w, http.StatusCreated, rule)
+}
+
+// handleAdminRemoveIgnored removes an ignore rule and reloads the ignore list
+func (e *Engine) handleAdminRemoveIgnored(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	err := ignored.Update(e.ignoredFilePath(), func(list *types.IgnoredControllersAndDevices) error {
+		if !slices.ContainsFunc(list.Rules, func(rule types.IgnoreRule) bool { return rule.ID == id }) {
+			return fmt.Errorf("ignore rule not found: %s", id)
+		}
+		list.Rules = slices.DeleteFunc(list.Rules, func(rule types.IgnoreRule) bool { return rule.ID == id })
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+
+	e.RunCommand(CommandReloadIgnoreList, "", controlSourceAdmin)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminInvalidateCaches drops the cached ignore rules and Kodelabs
+// mappings, see CommandInvalidateCaches
+func (e *Engine) handleAdminInvalidateCaches(w http.ResponseWriter, r *http.Request) {
+	e.runAdminCommand(w, CommandInvalidateCaches, "")
+}
+
+// handleAdminLogLevels returns the global log level and the per logger overrides
+func (e *Engine) handleAdminLogLevels(w http.ResponseWriter, r *http.Request) {
+	globalLevel, loggerLevels := loglevel.Levels()
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"level":   globalLevel,
+		"loggers": loggerLevels,
+	})
+}
+
+// handleAdminSetLogLevel changes the global log level, or the level of a named
+// logger such as "workers" or "kafka.producer". The level "reset" removes the
+// override of a named logger.
+func (e *Engine) handleAdminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var request logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid log level request: %w", err))
+		return
+	}
+
+	setting := request.Level
+	if name := r.PathValue("name"); name != "" {
+		setting = fmt.Sprintf("%s=%s", name, request.Level)
+	}
+
+	e.runAdminCommand(w, CommandSetLogLevel, setting)
+}
+
+// handleAdminCommand runs a control command, the request body is its argument
+func (e *Engine) handleAdminCommand(w http.ResponseWriter, r *http.Request) {
+	arg, err := readAdminBody(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	e.runAdminCommand(w, r.PathValue("name"), arg)
+}
+
+// runAdminCommand runs a control command and writes its result
+func (e *Engine) runAdminCommand(w http.ResponseWriter, name string, arg string) {
+	result, err := e.RunCommand(name, arg, controlSourceAdmin)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]string{"result": result})
+}
+
+// ignoredFilePath returns the current path of the ignore rules file
+func (e *Engine) ignoredFilePath() string {
+	e.controlMu.Lock()
+	defer e.controlMu.Unlock()
+
+	return e.cfg.App.Runtime.IgnoredFilePath
+}
+
+// readAdminBody reads a request body of at most 64 KiB
+func readAdminBody(r *http.Request) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// writeAdminJSON writes a JSON response
+func writeAdminJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeAdminError writes a JSON error response
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/archive"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	"go.uber.org/zap"
+)
+
+func (e *Engine) startArchiveWriter() {
+	if !e.cfg.App.Archive.Enabled {
+		e.verboseDebug("Payload archive disabled")
+		return
+	}
+
+	e.logger.Info("Starting payload archive", zap.String("dir", e.cfg.App.Archive.Dir), zap.Int("retention_days", e.cfg.App.Archive.RetentionDays))
+
+	archiveWriter, err := archive.NewWriter(&e.cfg.App.Archive, loglevel.Logger("archive"))
+	if err != nil {
+		e.logger.Error("Failed to create payload archive", zap.Error(err))
+		return
+	}
+
+	e.archiveWriter = archiveWriter
+}
+
+// archiveMessage writes a consumed message to the archive file of its customer.
+// Messages whose customer could not be resolved are archived as unknown.
+func (e *Engine) archiveMessage(data []byte, msgCtx *workers.MessageContext) {
+	if e.archiveWriter == nil {
+		return
+	}
+
+	customer := msgCtx.Customer
+	if customer == "" {
+		customer = archive.UnknownCustomer
+	}
+
+	if err := e.archiveWriter.Write(customer, data, time.Now()); err != nil {
+		msgCtx.Logger(e.logger).Error("Failed to archive message", zap.Error(err))
+		e.stats.recordError(errorClassArchive)
+	}
+}
@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/codec"
+	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// headerBatchVersion is the Kafka header that marks a record as a batch and
+// carries its envelope version
+const headerBatchVersion = "batch_version"
+
+// batcher collects outputs per topic and flushes a topic's batch once it
+// holds max records or its first output waited max wait
+type batcher struct {
+	mu         sync.Mutex
+	maxRecords int
+	maxWait    time.Duration
+	pending    map[string]*pendingBatch
+	flush      func(topic string, records []batchRecord) error
+	closed     bool
+}
+
+// batchRecord is an output and the span context of the message it was built
+// from, which the batch links to
+type batchRecord struct {
+	record      codec.Record
+	spanContext trace.SpanContext
+}
+
+type pendingBatch struct {
+	records []batchRecord
+	timer   *time.Timer
+}
+
+func newBatcher(maxRecords int, maxWait time.Duration, flush func(topic string, records []batchRecord) error) *batcher {
+	return &batcher{
+		maxRecords: maxRecords,
+		maxWait:    maxWait,
+		pending:    make(map[string]*pendingBatch),
+		flush:      flush,
+	}
+}
+
+// add adds an output to the batch of its topic. Batches are flushed while
+// holding the lock, so the batches of a topic are sent in order. It returns
+// the error of the batch it flushed, if any.
+func (b *batcher) add(ctx context.Context, topic string, record codec.Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	item := batchRecord{record: record, spanContext: trace.SpanContextFromContext(ctx)}
+
+	if b.closed {
+		return b.flush(topic, []batchRecord{item})
+	}
+
+	batch, ok := b.pending[topic]
+	if !ok {
+		batch = &pendingBatch{}
+		batch.timer = time.AfterFunc(b.maxWait, func() { b.expire(topic, batch) })
+		b.pending[topic] = batch
+	}
+
+	batch.records = append(batch.records, item)
+	if len(batch.records) >= b.maxRecords {
+		batch.timer.Stop()
+		delete(b.pending, topic)
+		return b.flush(topic, batch.records)
+	}
+
+	return nil
+}
+
+// expire flushes a batch whose max wait passed, unless it was flushed already.
+// A failed batch was already logged and counted by flush, and is not returned
+// to a later add so it does not stop the worker of an unrelated message.
+func (b *batcher) expire(topic string, batch *pendingBatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending[topic] != batch {
+		return
+	}
+
+	delete(b.pending, topic)
+	b.flush(topic, batch.records)
+}
+
+// close flushes the pending batches. Outputs added afterwards are sent as
+// batches of one. Failed batches were already logged and counted by flush.
+func (b *batcher) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for topic, batch := range b.pending {
+		batch.timer.Stop()
+		b.flush(topic, batch.records)
+	}
+	b.pending = make(map[string]*pendingBatch)
+}
+
+// startBatcher enables batching of outputs if it is configured
+func (e *Engine) startBatcher() {
+	if !e.cfg.App.Batch.Enabled {
+		e.verboseDebug("Output batching disabled")
+		return
+	}
+
+	e.logger.Info("Batching outputs", zap.Int("max_records", e.cfg.App.Batch.MaxRecords), zap.Int("max_wait_ms", e.cfg.App.Batch.MaxWaitMs))
+
+	var kafkaProducerLogger *zap.Logger
+	if flags.FlagKafkaLogging {
+		kafkaProducerLogger = loglevel.Logger("kafka.producer")
+	} else {
+		kafkaProducerLogger = zap.NewNop()
+	}
+
+	e.batcher = newBatcher(e.cfg.App.Batch.MaxRecords, time.Duration(e.cfg.App.Batch.MaxWaitMs)*time.Millisecond, func(topic string, records []batchRecord) error {
+		return e.sendBatch(topic, records, kafkaProducerLogger)
+	})
+}
+
+// sendBatch sends a batch of outputs as one record. The batch outlives the
+// messages it was built from, and is flushed on shutdown after the engine
+// context is cancelled, so it is sent in a trace of its own that links to the
+// traces of those messages.
+func (e *Engine) sendBatch(topic string, batch []batchRecord, logger *zap.Logger) (err error) {
+	records := make([]codec.Record, 0, len(batch))
+	links := make([]trace.Link, 0, len(batch))
+	var ids []string
+	seen := make(map[string]bool)
+	for _, item := range batch {
+		records = append(records, item.record)
+		if item.spanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: item.spanContext})
+		}
+		if id := item.record.ID.String(); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	ctx, span := tracing.Start(context.Background(), "batch",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", topic),
+			attribute.Int("messaging.batch.message_count", len(records)),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	c := e.outputCodec(topic)
+
+	data, err := c.EncodeBatch(records)
+	if err != nil {
+		logger.Error("Failed to serialize batch", zap.String("kafka_topic", topic), zap.Int("count", len(records)), zap.Error(err))
+		e.stats.recordError(errorClassSerialize)
+		return fmt.Errorf("failed to serialize batch: %w", err)
+	}
+
+	err = e.sendMessage(ctx, topic, data,
+		messageIDHeader(strings.Join(ids, ",")),
+		kafka.Header{Key: headerBatchVersion, Value: []byte(strconv.Itoa(codec.EnvelopeVersion))},
+		contentTypeHeader(c),
+	)
+	if err != nil {
+		logger.Error("Failed to send batch to Kafka", zap.String("kafka_topic", topic), zap.Int("count", len(records)), zap.Error(err))
+		e.stats.recordError(errorClassProduce)
+		return fmt.Errorf("failed to send batch of %d outputs: %w", len(records), err)
+	}
+
+	e.stats.recordProducedBatch(len(records))
+
+	return nil
+}
+
+// sendOutput sends an output of a consumed message to a topic, either in a
+// batch or as a record of its own. Failures are recorded in the statistics.
+// In batch mode the error is that of the batch the output filled, which may
+// hold outputs of earlier messages.
+func (e *Engine) sendOutput(ctx context.Context, topic string, record codec.Record) error {
+	if e.batcher != nil {
+		return e.batcher.add(ctx, topic, record)
+	}
+
+	c := e.outputCodec(topic)
+
+	data, err := c.Encode(record)
+	if err != nil {
+		e.stats.recordError(errorClassSerialize)
+		return err
+	}
+
+	if err := e.sendMessage(ctx, topic, data, messageIDHeader(record.ID.String()), contentTypeHeader(c)); err != nil {
+		e.stats.recordError(errorClassProduce)
+		return err
+	}
+	e.stats.recordProduced()
+
+	return nil
+}
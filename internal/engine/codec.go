@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/codec"
+	"go.uber.org/zap"
+)
+
+// startOutputCodecs resolves the codec of every output topic. Topics without
+// a codec of their own use the default codec.
+func (e *Engine) startOutputCodecs() {
+	defaultCodec, err := codec.Get(e.cfg.App.Outputs.Codec)
+	if err != nil {
+		e.logger.Error("Invalid output codec, using JSON", zap.Error(err))
+		defaultCodec = codec.JSON{}
+	}
+	e.defaultCodec = defaultCodec
+
+	e.codecs = make(map[string]codec.Codec, len(e.cfg.App.Outputs.Codecs))
+	for stage, name := range e.cfg.App.Outputs.Codecs {
+		c, err := codec.Get(name)
+		if err != nil {
+			e.logger.Error("Invalid output codec, using the default codec", zap.String("stage", stage), zap.Error(err))
+			continue
+		}
+
+		topic := e.kafkaTopic(stage)
+		e.codecs[topic] = c
+		e.verboseDebug("Output codec", zap.String("kafka_topic", topic), zap.String("codec", c.Name()))
+	}
+}
+
+// outputCodec returns the codec outputs to a topic are encoded with
+func (e *Engine) outputCodec(topic string) codec.Codec {
+	if c, ok := e.codecs[topic]; ok {
+		return c
+	}
+
+	return e.defaultCodec
+}
+
+// contentTypeHeader returns the Kafka header with the content type of a codec
+func contentTypeHeader(c codec.Codec) kafka.Header {
+	return kafka.Header{Key: codec.HeaderContentType, Value: []byte(c.ContentType())}
+}
@@ -0,0 +1,356 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/kodelabs"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Control commands. In the control directory the file name is the command and
+// the file content is its argument.
+const (
+	CommandPause            = "pause"
+	CommandResume           = "resume"
+	CommandReloadConfig     = "reload-config"
+	CommandReloadIgnoreList = "reload-ignore-list"
+	CommandDumpState        = "dump-state"
+	CommandSetLogLevel      = "set-log-level"
+	// CommandInvalidateCaches drops the cached ignore rules and Kodelabs
+	// mappings so that they are read again from disk. Devices are looked up in
+	// the device repository for every message and are not cached.
+	CommandInvalidateCaches = "invalidate-caches"
+)
+
+// Sources of control commands
+const (
+	controlSourceFile  = "control file"
+	controlSourceAdmin = "admin API"
+)
+
+// maxTransitions is the number of control command transitions kept in the
+// persisted state
+const maxTransitions = 50
+
+// transition is a control command recorded in the persisted state
+type transition struct {
+	Time    string `json:"time"`
+	Command string `json:"command"`
+	Source  string `json:"source"`
+	Result  string `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// reloadableConfigKeys are the app config keys, or key prefixes, that are
+// applied by reload-config without a restart
+var reloadableConfigKeys = []string{
+	"logging.level",
+	"topics.",
+	"runtime.ignored_file_path",
+	"kodelabs.mapping_dir",
+}
+
+// RunCommand runs a control command and acknowledges it in the connections log.
+// Commands are run one at a time.
+func (e *Engine) RunCommand(name string, arg string, source string) (string, error) {
+	e.controlMu.Lock()
+	defer e.controlMu.Unlock()
+
+	result, err := e.runCommand(name, strings.TrimSpace(arg))
+
+	now := time.Now().Format(time.RFC3339)
+	t := transition{Time: now, Command: name, Source: source, Result: result}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	e.recordTransition(t)
+
+	if err != nil {
+		e.logger.Error("Control command failed", zap.String("command", name), zap.String("source", source), zap.Error(err))
+		coreutils.WriteToLogFile(e.connectionsLogFilePath, fmt.Sprintf("%s: Control command %s (%s) failed: %s\n", now, name, source, err))
+		return "", err
+	}
+
+	e.logger.Info("Control command executed", zap.String("command", name), zap.String("source", source), zap.String("result", result))
+	coreutils.WriteToLogFile(e.connectionsLogFilePath, fmt.Sprintf("%s: Control command %s (%s): %s\n", now, name, source, result))
+
+	return result, nil
+}
+
+// recordTransition adds a control command to the transitions in the persisted
+// state, dropping the oldest once maxTransitions is reached
+func (e *Engine) recordTransition(t transition) {
+	e.transitions = append(e.transitions, t)
+	if len(e.transitions) > maxTransitions {
+		e.transitions = e.transitions[len(e.transitions)-maxTransitions:]
+	}
+
+	e.statePersister.Set("app.last_transition", t)
+	e.statePersister.Set("app.transitions", e.transitions)
+}
+
+// runCommand dispatches a control command
+func (e *Engine) runCommand(name string, arg string) (string, error) {
+	switch name {
+	case CommandPause:
+		return e.setPaused(true), nil
+	case CommandResume:
+		return e.setPaused(false), nil
+	case CommandReloadConfig:
+		return e.reloadConfig()
+	case CommandReloadIgnoreList:
+		rules, err := workers.ReloadIgnored()
+		if err != nil {
+			return "", fmt.Errorf("failed to reload ignore list: %w", err)
+		}
+		return fmt.Sprintf("ignore list reloaded, %d rules", rules), nil
+	case CommandDumpState:
+		return e.dumpState()
+	case CommandSetLogLevel:
+		if err := loglevel.Set(arg); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("log level set: %s", arg), nil
+	case CommandInvalidateCaches:
+		workers.SetIgnoredFilePath(e.cfg.App.Runtime.IgnoredFilePath)
+		e.kodelabsTransformer.Store(kodelabs.NewTransformer(e.cfg.App.Kodelabs.MappingDir))
+		return "ignore rule and Kodelabs mapping caches invalidated", nil
+	default:
+		return "", fmt.Errorf("unknown control command: %s", name)
+	}
+}
+
+// setPaused pauses or resumes taking messages from the message source
+func (e *Engine) setPaused(paused bool) string {
+	if e.paused.Load() == paused {
+		if paused {
+			return "already paused"
+		}
+		return "already running"
+	}
+
+	// Devices were not heard while paused, restart their silence before the
+	// liveness checker runs again
+	if !paused && e.livenessTracker != nil {
+		e.livenessTracker.Rearm(time.Now())
+	}
+	e.paused.Store(paused)
+
+	// Until the message source is started, startWorker applies the paused
+	// state to it instead
+	select {
+	case <-e.kafkaConsumerConnectedCh:
+		if paused {
+			e.source.Pause()
+		} else {
+			e.source.Resume()
+		}
+	default:
+	}
+
+	// Wake the worker so that it picks up the new state
+	select {
+	case e.pauseChanged <- struct{}{}:
+	default:
+	}
+
+	if paused {
+		e.statePersister.Set("app.status", "paused")
+		return "consumption paused"
+	}
+
+	e.statePersister.Set("app.status", "running")
+	return "consumption resumed"
+}
+
+// reloadConfig reloads app.yaml and applies the settings that can change
+// without a restart. The running configuration is kept if the new one is invalid.
+func (e *Engine) reloadConfig() (string, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to reload configuration: %w", err)
+	}
+
+	level, err := zapcore.ParseLevel(cfg.App.Logging.Level)
+	if err != nil {
+		return "", err
+	}
+
+	topicMatcher, err := workers.NewTopicMatcher(cfg.App.Topics.Templates, cfg.App.Topics.CustomerAliases)
+	if err != nil {
+		return "", err
+	}
+
+	var restartRequired []string
+	current := make(map[string]string)
+	for _, value := range e.cfg.EffectiveConfig(true) {
+		current[value.Key] = value.Value
+	}
+	for _, value := range cfg.EffectiveConfig(true) {
+		if current[value.Key] != value.Value && !isReloadableConfigKey(value.Key) {
+			restartRequired = append(restartRequired, value.Key)
+		}
+	}
+
+	loglevel.SetLevel(level)
+	e.topicMatcher.Store(topicMatcher)
+	workers.SetIgnoredFilePath(cfg.App.Runtime.IgnoredFilePath)
+	e.kodelabsTransformer.Store(kodelabs.NewTransformer(cfg.App.Kodelabs.MappingDir))
+
+	e.cfg.App.Logging.Level = cfg.App.Logging.Level
+	e.cfg.App.Topics = cfg.App.Topics
+	e.cfg.App.Runtime.IgnoredFilePath = cfg.App.Runtime.IgnoredFilePath
+	e.cfg.App.Kodelabs.MappingDir = cfg.App.Kodelabs.MappingDir
+
+	if len(restartRequired) > 0 {
+		e.logger.Warn("Configuration changes require a restart", zap.Strings("keys", restartRequired))
+		return fmt.Sprintf("configuration reloaded, restart required for: %s", strings.Join(restartRequired, ", ")), nil
+	}
+
+	return "configuration reloaded", nil
+}
+
+// isReloadableConfigKey reports whether a config key is applied by reload-config
+func isReloadableConfigKey(key string) bool {
+	for _, reloadable := range reloadableConfigKeys {
+		if key == reloadable || (strings.HasSuffix(reloadable, ".") && strings.HasPrefix(key, reloadable)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// State returns a snapshot of the engine state. The caller must hold
+// controlMu, as reload-config changes the configuration it includes.
+func (e *Engine) State() map[string]any {
+	status := "running"
+	if e.paused.Load() {
+		status = "paused"
+	}
+
+	globalLevel, loggerLevels := loglevel.Levels()
+
+	state := map[string]any{
+		"time":        time.Now().Format(time.RFC3339),
+		"status":      status,
+		"name":        e.cfg.System.AppName,
+		"version":     e.cfg.System.AppVersion,
+		"start_time":  startTime.Format(time.RFC3339),
+		"uptime":      time.Since(startTime).Round(time.Second).String(),
+		"stats":       e.stats.snapshot(),
+		"log_level":   globalLevel,
+		"log_levels":  loggerLevels,
+		"config":      e.cfg.EffectiveConfig(false),
+		"environment": flags.FlagEnvironment,
+	}
+
+	if e.livenessTracker != nil {
+		state["liveness"] = e.livenessTracker.Snapshot()
+	}
+
+	if e.spoolSink != nil {
+		state["spool"] = e.spoolSink.spool.Stats()
+	}
+
+	if e.discoveryRegistry != nil {
+		state["unknown_devices"] = len(e.discoveryRegistry.List())
+	}
+
+	return state
+}
+
+// dumpState writes a snapshot of the engine state to the dumps directory of
+// the control directory
+func (e *Engine) dumpState() (string, error) {
+	data, err := json.MarshalIndent(e.State(), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize state: %w", err)
+	}
+
+	dir := filepath.Join(e.cfg.App.Runtime.ControlDir, "dumps")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create dumps directory: %w", err)
+	}
+
+	filePath := filepath.Join(dir, fmt.Sprintf("state-%s.json", time.Now().Format("20060102T150405")))
+	if err := os.WriteFile(filePath, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write state dump: %w", err)
+	}
+
+	return fmt.Sprintf("state dumped to %s", filePath), nil
+}
+
+// watchControlDir runs the command files dropped into the control directory,
+// oldest first, and removes them. Hidden files are skipped so that commands
+// can be written to a temporary file and renamed into place.
+func (e *Engine) watchControlDir(controlDir string) {
+	if err := os.MkdirAll(controlDir, 0o755); err != nil {
+		e.logger.Error("Failed to create control directory", zap.Error(err))
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.stopFileChan:
+			return
+		case <-ticker.C:
+			entries, err := os.ReadDir(controlDir)
+			if err != nil {
+				e.logger.Error("Failed to read control directory", zap.Error(err))
+				continue
+			}
+
+			var commandFiles []os.FileInfo
+			for _, entry := range entries {
+				if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+					continue
+				}
+
+				info, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				commandFiles = append(commandFiles, info)
+			}
+
+			sort.Slice(commandFiles, func(i, j int) bool {
+				return commandFiles[i].ModTime().Before(commandFiles[j].ModTime())
+			})
+
+			for _, info := range commandFiles {
+				filePath := filepath.Join(controlDir, info.Name())
+
+				arg, err := os.ReadFile(filePath)
+				if err != nil {
+					e.logger.Error("Failed to read control file", zap.String("path", filePath), zap.Error(err))
+					continue
+				}
+
+				if err := os.Remove(filePath); err != nil {
+					e.logger.Error("Failed to remove control file", zap.String("path", filePath), zap.Error(err))
+					continue
+				}
+
+				e.RunCommand(info.Name(), string(arg), controlSourceFile)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/discovery"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+	"go.uber.org/zap"
+)
+
+func (e *Engine) startDiscoveryRegistry() {
+	discoveryCfg := e.cfg.App.Discovery
+	if !discoveryCfg.Enabled {
+		e.verboseDebug("Unknown device discovery disabled")
+		return
+	}
+
+	e.logger.Info("Starting unknown device discovery", zap.String("path", discoveryCfg.FilePath), zap.String("topic", e.discoveryTopic()))
+
+	registry, err := discovery.NewRegistry(discoveryCfg.FilePath, discoveryCfg.MaxDevices, discoveryCfg.MaxSampleSize)
+	if err != nil {
+		e.logger.Error("Failed to load unknown device registry", zap.Error(err))
+		return
+	}
+
+	e.discoveryRegistry = registry
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.runDiscoveryFlusher()
+	}()
+}
+
+// discoveryTopic returns the Kafka topic newly discovered devices are sent to
+func (e *Engine) discoveryTopic() string {
+	return e.kafkaTopic(e.cfg.App.Discovery.Stage)
+}
+
+// runDiscoveryFlusher periodically saves the registry to disk
+func (e *Engine) runDiscoveryFlusher() {
+	interval := time.Duration(e.cfg.App.Discovery.FlushInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			e.saveDiscoveryRegistry()
+			return
+		case <-ticker.C:
+			e.saveDiscoveryRegistry()
+		}
+	}
+}
+
+func (e *Engine) saveDiscoveryRegistry() {
+	if err := e.discoveryRegistry.Save(); err != nil {
+		e.logger.Error("Failed to save unknown device registry", zap.Error(err))
+	}
+}
+
+// recordUnknownDevice records an unknown device and publishes it when it is first seen
+func (e *Engine) recordUnknownDevice(ctx context.Context, notFoundErr *workers.DeviceNotFoundError, message []byte, msgCtx *workers.MessageContext) {
+	if e.discoveryRegistry == nil {
+		return
+	}
+
+	device, isNew := e.discoveryRegistry.Record(discovery.UnknownDevice{
+		DeviceIdentifier: notFoundErr.DeviceIdentifier,
+		Customer:         notFoundErr.Customer,
+		Topic:            notFoundErr.Topic,
+		SiteName:         notFoundErr.SiteName,
+		DeviceName:       notFoundErr.DeviceName,
+	}, message, time.Now())
+
+	if !isNew {
+		return
+	}
+
+	logger := msgCtx.Logger(e.logger)
+	logger.Info("Discovered unknown device", zap.String("deviceID", device.DeviceIdentifier), zap.String("customer", device.Customer))
+
+	if e.sink == nil {
+		return
+	}
+
+	serializedDevice, err := json.Marshal(device)
+	if err != nil {
+		logger.Error("Failed to serialize unknown device", zap.Error(err))
+		return
+	}
+
+	p := payload.Payload{
+		ID:               coreutils.GenerateUUID(),
+		MqttTopic:        device.Topic,
+		Message:          serializedDevice,
+		MessageTimestamp: device.FirstSeen,
+	}
+
+	serializedPayload, err := p.Serialize()
+	if err != nil {
+		logger.Error("Failed to serialize unknown device payload", zap.Error(err))
+		return
+	}
+
+	if err := e.sendMessage(ctx, e.discoveryTopic(), serializedPayload, messageIDHeader(msgCtx.ID)); err != nil {
+		logger.Error("Failed to send unknown device to Kafka", zap.Error(err))
+		e.stats.recordError(errorClassProduce)
+		return
+	}
+	e.stats.recordProduced()
+}
@@ -6,12 +6,20 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/johandrevandeventer/kafkaclient/consumer"
-	"github.com/johandrevandeventer/kafkaclient/producer"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/archive"
+	"github.com/johandrevandeventer/mqtt-worker/internal/codec"
 	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/devicerepo"
+	"github.com/johandrevandeventer/mqtt-worker/internal/discovery"
 	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/influxdb"
+	"github.com/johandrevandeventer/mqtt-worker/internal/kodelabs"
+	"github.com/johandrevandeventer/mqtt-worker/internal/liveness"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
 	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
 	"github.com/johandrevandeventer/persist"
 	"go.uber.org/zap"
@@ -34,15 +42,35 @@ type Engine struct {
 	stopFileFilePath         string
 	connectionsLogFilePath   string
 	wg                       sync.WaitGroup
-	kafkaProducerPool        *producer.KafkaProducerPool
-	kafkaConsumer            *consumer.KafkaConsumer
+	source                   MessageSource
+	sink                     MessageSink
+	devices                  workers.DeviceRepository
+	deliveryChan             chan kafka.Event
+	deliveryReportsDone      chan struct{}
+	influxDBWriter           *influxdb.Writer
+	archiveWriter            *archive.Writer
+	spoolSink                *spoolSink
+	batcher                  *batcher
+	defaultCodec             codec.Codec
+	codecs                   map[string]codec.Codec
+	kodelabsTransformer      atomic.Pointer[kodelabs.Transformer]
+	topicMatcher             atomic.Pointer[workers.TopicMatcher]
+	stats                    *runtimeStats
+	livenessTracker          *liveness.Tracker
+	discoveryRegistry        *discovery.Registry
+	controlMu                sync.Mutex
+	paused                   atomic.Bool
+	pauseChanged             chan struct{}
+	transitions              []transition
 }
 
 // NewEngine creates a new Engine instance
 func NewEngine(ctx context.Context, cfg *config.Config, logger *zap.Logger, statePersister *persist.FilePersister) *Engine {
 	ctx, cancel := context.WithCancel(ctx)
 
-	return &Engine{
+	workers.SetIgnoredFilePath(cfg.App.Runtime.IgnoredFilePath)
+
+	e := &Engine{
 		ctx:                      ctx,
 		cancelFunc:               cancel,
 		cfg:                      cfg,
@@ -53,7 +81,31 @@ func NewEngine(ctx context.Context, cfg *config.Config, logger *zap.Logger, stat
 		tmpFilePath:              cfg.App.Runtime.TmpDir,
 		stopFileFilePath:         cfg.App.Runtime.StopFileFilepath,
 		connectionsLogFilePath:   cfg.App.Runtime.ConnectionsLogFilePath,
+		stats:                    newRuntimeStats(),
+		pauseChanged:             make(chan struct{}, 1),
+		devices:                  devicerepo.NewMySQL(),
 	}
+	e.kodelabsTransformer.Store(kodelabs.NewTransformer(cfg.App.Kodelabs.MappingDir))
+
+	return e
+}
+
+// SetMessageSource replaces the Kafka consumer as the source of messages. It
+// must be called before Run.
+func (e *Engine) SetMessageSource(source MessageSource) {
+	e.source = source
+}
+
+// SetMessageSink replaces the Kafka producer as the sink of messages. It must
+// be called before Run.
+func (e *Engine) SetMessageSink(sink MessageSink) {
+	e.sink = sink
+}
+
+// SetDeviceRepository replaces the devices database as the repository devices
+// are looked up in. It must be called before Run.
+func (e *Engine) SetDeviceRepository(devices workers.DeviceRepository) {
+	e.devices = devices
 }
 
 // Run starts the Engine
@@ -104,6 +156,14 @@ func (e *Engine) Run() {
 func (e *Engine) start() {
 	e.logger.Info("Background worker started")
 
+	// Start the message sink before any goroutine can read it
+	if e.sink == nil {
+		e.startKafkaProducer()
+	}
+	// Spool messages the sink cannot take while it is unavailable
+	e.startSpool()
+	e.startDeliveryReports()
+
 	// Watch for stop file
 	e.wg.Add(1)
 	go func() {
@@ -111,17 +171,42 @@ func (e *Engine) start() {
 		e.WatchStopFile(e.stopFileFilePath)
 	}()
 
+	// Watch for control commands
 	e.wg.Add(1)
 	go func() {
 		defer e.wg.Done()
-		select {
-		case <-e.ctx.Done():
-			return
-		default:
-			e.startKafkaProducer()
-		}
+		e.watchControlDir(e.cfg.App.Runtime.ControlDir)
+	}()
+
+	// Watch for reload, pause and resume signals
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.watchSignals()
 	}()
 
+	// Periodically flush runtime statistics
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.runStatsFlusher()
+	}()
+
+	// Start the InfluxDB writer before any worker can produce data
+	e.startInfluxDBWriter()
+	e.startArchiveWriter()
+	e.startOutputCodecs()
+	e.startBatcher()
+
+	// Start tracking device and controller liveness
+	e.startLivenessTracker()
+
+	// Start recording unknown devices
+	e.startDiscoveryRegistry()
+
+	// Start the admin HTTP API
+	e.startAdminServer()
+
 	e.wg.Add(1)
 	go func() {
 		defer e.wg.Done()
@@ -129,8 +214,12 @@ func (e *Engine) start() {
 		case <-e.ctx.Done():
 			return
 		default:
-			e.startKafkaConsumer()
-			// Once kafkaConsumer is initialized, send a signal
+			if e.source == nil {
+				e.startKafkaConsumer()
+			} else {
+				e.startMessageSource()
+			}
+			// Once the message source is initialized, send a signal
 			close(e.kafkaConsumerConnectedCh)
 		}
 	}()
@@ -142,7 +231,7 @@ func (e *Engine) start() {
 		case <-e.ctx.Done():
 			return
 		case <-e.kafkaConsumerConnectedCh:
-			if e.kafkaConsumer != nil {
+			if e.source != nil {
 				e.startWorker()
 			}
 		}
@@ -164,19 +253,40 @@ func (e *Engine) cleanup() {
 		e.verboseDebug(response)
 	}
 
-	// Close Kafka producer pool
-	e.verboseDebug("Closing Kafka producer pool")
-	if e.kafkaProducerPool != nil {
-		e.kafkaProducerPool.Close()
+	// Flush pending batches before the sink is closed
+	if e.batcher != nil {
+		e.verboseDebug("Flushing output batches")
+		e.batcher.close()
+	}
+
+	// Close message sink, which waits for the delivery reports of the messages
+	// flushed on close
+	e.verboseDebug("Closing message sink")
+	if e.sink != nil {
+		e.sink.Close()
+	}
+	e.verboseDebug("Message sink closed")
+
+	// Close message source
+	e.verboseDebug("Closing message source")
+	if e.source != nil {
+		e.source.Close()
+	}
+	e.verboseDebug("Message source closed")
+
+	// Flush and close InfluxDB writer
+	if e.influxDBWriter != nil {
+		e.verboseDebug("Closing InfluxDB writer")
+		e.influxDBWriter.Close()
+		e.verboseDebug("InfluxDB writer closed")
 	}
-	e.verboseDebug("Kafka producer pool closed")
 
-	// Close Kafka consumer
-	e.verboseDebug("Closing Kafka consumer")
-	if e.kafkaConsumer != nil {
-		e.kafkaConsumer.Close()
+	// Flush and close payload archive
+	if e.archiveWriter != nil {
+		e.verboseDebug("Closing payload archive")
+		e.archiveWriter.Close()
+		e.verboseDebug("Payload archive closed")
 	}
-	e.verboseDebug("Kafka consumer closed")
 }
 
 // Stop stops the Engine
@@ -200,6 +310,7 @@ func (e *Engine) Stop() {
 	// Log application stop
 	coreutils.WriteToLogFile(e.connectionsLogFilePath, fmt.Sprintf("%s: App stopped\n", endTime.Format(time.RFC3339)))
 
+	e.flushStats()
 	e.statePersister.Set("app.status", "stopped")
 	e.statePersister.Set("app.end_time", endTime.Format(time.RFC3339))
 	e.statePersister.Set("app.duration", duration.String())
@@ -0,0 +1,313 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/codec"
+	"github.com/johandrevandeventer/mqtt-worker/internal/config"
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/system"
+	"github.com/johandrevandeventer/mqtt-worker/internal/devicerepo"
+	"github.com/johandrevandeventer/mqtt-worker/internal/kodelabs"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// traceParent is the W3C trace context the test messages are produced with
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// newTestEngine creates an engine that consumes from source and publishes to
+// sink, with two Acme power meters in its device repository of which CW-1002
+// is on the ignore list
+func newTestEngine(t *testing.T, source MessageSource, sink MessageSink) *Engine {
+	t.Helper()
+
+	runtimeDir := t.TempDir()
+
+	cfg := &config.Config{
+		System: &system.SystemConfig{},
+		App:    app.DefaultAppConfig(runtimeDir),
+	}
+	cfg.App.Topics.Templates = []string{"Rubicon/mqtt/{customer}/#"}
+	cfg.App.Runtime.IgnoredFilePath = filepath.Join(runtimeDir, "ignored.json")
+
+	ignored := `{"rules": [{"id": "muted", "kind": "device", "pattern": "CW-1002", "match": "exact"}]}`
+	if err := os.WriteFile(cfg.App.Runtime.IgnoredFilePath, []byte(ignored), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sets the trace context propagator, spans are not recorded
+	if _, err := tracing.Init(&cfg.App.Tracing, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	customer := models.Customer{ID: uuid.New(), Name: "Acme"}
+	site := models.Site{ID: uuid.New(), Name: "Acme Head Office", CustomerID: customer.ID, Customer: customer}
+	devices := devicerepo.NewMemory([]models.Device{
+		{
+			Controller:           "CloudWatch",
+			ControllerIdentifier: "CW-1001",
+			DeviceType:           "PowerMeter",
+			DeviceIdentifier:     "CW-1001",
+			DeviceName:           "Main Incomer",
+			SiteID:               site.ID,
+			Site:                 site,
+		},
+		{
+			Controller:           "CloudWatch",
+			ControllerIdentifier: "CW-1002",
+			DeviceType:           "PowerMeter",
+			DeviceIdentifier:     "CW-1002",
+			DeviceName:           "Muted Meter",
+			SiteID:               site.ID,
+			Site:                 site,
+		},
+	})
+
+	e := NewEngine(context.Background(), cfg, zap.NewNop(), nil)
+	t.Cleanup(e.cancelFunc)
+
+	e.SetMessageSource(source)
+	e.SetMessageSink(sink)
+	e.SetDeviceRepository(devices)
+	e.startOutputCodecs()
+
+	return e
+}
+
+// publishPowerMeter publishes a power meter reading from the MQTT bridge
+func publishPowerMeter(t *testing.T, source *MemorySource, deviceIdentifier string) uuid.UUID {
+	t.Helper()
+
+	message, err := json.Marshal(map[string]any{
+		"site_name":         "Acme Head Office",
+		"device_identifier": deviceIdentifier,
+		"device_name":       "Meter",
+		"timestamp":         "2025-03-14T10:15:30",
+		"V1":                231.4,
+		"I1":                12.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := payload.Payload{
+		ID:               uuid.New(),
+		MqttTopic:        "Rubicon/mqtt/acme/" + deviceIdentifier,
+		Message:          message,
+		MessageTimestamp: time.Now(),
+	}
+
+	data, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source.Publish(data, kafka.Header{Key: "traceparent", Value: []byte(traceParent)})
+
+	return p.ID
+}
+
+// TestWorkerPublishesOutputs runs messages through the worker and checks what
+// was published to every topic
+func TestWorkerPublishesOutputs(t *testing.T) {
+	source := NewMemorySource(10)
+	sink := NewMemorySink()
+	e := newTestEngine(t, source, sink)
+
+	id := publishPowerMeter(t, source, "CW-1001")
+	publishPowerMeter(t, source, "CW-1002") // Ignored
+	publishPowerMeter(t, source, "CW-9999") // Unknown
+	source.Close()
+
+	// Returns once the source is closed and drained
+	e.startWorker()
+
+	influxDBTopic := e.kafkaTopic(e.cfg.App.Kafka.InfluxDBStage)
+	kodelabsTopic := e.kafkaTopic(e.cfg.App.Kafka.KodelabsStage)
+
+	if got := sink.Topics(); len(got) != 2 || !contains(got, influxDBTopic) || !contains(got, kodelabsTopic) {
+		t.Fatalf("published to %v, want %s and %s", got, influxDBTopic, kodelabsTopic)
+	}
+
+	influxDBMessages := sink.Messages(influxDBTopic)
+	if len(influxDBMessages) != 2 {
+		t.Fatalf("published %d messages to %s, want 2", len(influxDBMessages), influxDBTopic)
+	}
+
+	for i, state := range []string{"Pre", "Post"} {
+		message := influxDBMessages[i]
+		assertHeaders(t, message)
+
+		recordID, data := decodeRecord(t, message)
+		if recordID != id {
+			t.Errorf("message %d has ID %s, want %s", i, recordID, id)
+		}
+
+		var ds types.DataStruct
+		if err := json.Unmarshal(data, &ds); err != nil {
+			t.Fatal(err)
+		}
+		if ds.State != state || ds.DeviceIdentifier != "CW-1001" || ds.CustomerName != "Acme" {
+			t.Errorf("message %d is %s data of %s for %s, want %s data of CW-1001 for Acme", i, ds.State, ds.DeviceIdentifier, ds.CustomerName, state)
+		}
+	}
+
+	kodelabsMessages := sink.Messages(kodelabsTopic)
+	if len(kodelabsMessages) != 1 {
+		t.Fatalf("published %d messages to %s, want 1", len(kodelabsMessages), kodelabsTopic)
+	}
+	assertHeaders(t, kodelabsMessages[0])
+
+	_, data := decodeRecord(t, kodelabsMessages[0])
+
+	var kodelabsMessage kodelabs.Message
+	if err := json.Unmarshal(data, &kodelabsMessage); err != nil {
+		t.Fatal(err)
+	}
+	if kodelabsMessage.Customer != "Acme" || len(kodelabsMessage.Points) == 0 {
+		t.Errorf("Kodelabs message for %q has %d points, want points for Acme", kodelabsMessage.Customer, len(kodelabsMessage.Points))
+	}
+
+	errorsByClass := e.stats.snapshot()["errors"].(map[string]uint64)
+	if errorsByClass[errorClassDeviceIgnored] != 1 || errorsByClass[errorClassDeviceNotFound] != 1 {
+		t.Errorf("errors %v, want one ignored device and one device not found", errorsByClass)
+	}
+}
+
+// TestWorkerPublishesBatches runs messages through the worker with batching
+// enabled and checks the batches carry the IDs of their messages
+func TestWorkerPublishesBatches(t *testing.T) {
+	source := NewMemorySource(10)
+	sink := NewMemorySink()
+	e := newTestEngine(t, source, sink)
+
+	e.cfg.App.Batch = app.BatchConfig{Enabled: true, MaxRecords: 2, MaxWaitMs: 60000}
+	e.startBatcher()
+
+	first := publishPowerMeter(t, source, "CW-1001")
+	second := publishPowerMeter(t, source, "CW-1001")
+	source.Close()
+
+	e.startWorker()
+	e.batcher.close()
+
+	// The Pre and Post data of a message fill a batch, the Kodelabs outputs of
+	// both messages share one
+	expected := map[string][]string{
+		e.kafkaTopic(e.cfg.App.Kafka.InfluxDBStage): {first.String(), second.String()},
+		e.kafkaTopic(e.cfg.App.Kafka.KodelabsStage): {first.String() + "," + second.String()},
+	}
+
+	for topic, ids := range expected {
+		messages := sink.Messages(topic)
+		if len(messages) != len(ids) {
+			t.Fatalf("published %d batches to %s, want %d", len(messages), topic, len(ids))
+		}
+
+		for i, message := range messages {
+			headers := make(map[string]string)
+			for _, header := range message.Headers {
+				headers[header.Key] = string(header.Value)
+			}
+
+			if headers[headerMessageID] != ids[i] {
+				t.Errorf("batch %d to %s has message IDs %q, want %q", i, topic, headers[headerMessageID], ids[i])
+			}
+			if headers[headerBatchVersion] != "1" {
+				t.Errorf("batch %d to %s has batch version %q, want 1", i, topic, headers[headerBatchVersion])
+			}
+		}
+	}
+}
+
+// TestBatchExpireErrorIsNotReturned checks that a batch failing when its max
+// wait passed is counted, but not returned to the next output
+func TestBatchExpireErrorIsNotReturned(t *testing.T) {
+	sink := &failingSink{MemorySink: NewMemorySink()}
+	e := newTestEngine(t, NewMemorySource(1), sink)
+
+	e.cfg.App.Batch = app.BatchConfig{Enabled: true, MaxRecords: 2, MaxWaitMs: 10}
+	e.startBatcher()
+
+	sink.down.Store(true)
+	if err := e.sendOutput(context.Background(), "output", codec.Record{ID: uuid.New(), Data: map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for e.stats.snapshot()["errors"].(map[string]uint64)[errorClassProduce] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired batch was not flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sink.down.Store(false)
+	if err := e.sendOutput(context.Background(), "output", codec.Record{ID: uuid.New(), Data: map[string]any{}}); err != nil {
+		t.Errorf("output after a failed expired batch returned %v, want nil", err)
+	}
+}
+
+// decodeRecord decodes the JSON envelope of a single output and returns its
+// message ID and data
+func decodeRecord(t *testing.T, message MemoryMessage) (uuid.UUID, json.RawMessage) {
+	t.Helper()
+
+	var envelope struct {
+		Version int `json:"version"`
+		Items   []struct {
+			ID   uuid.UUID       `json:"id"`
+			Data json.RawMessage `json:"data"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(message.Value, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Version != codec.EnvelopeVersion || len(envelope.Items) != 1 {
+		t.Fatalf("output is a version %d envelope of %d items, want version %d of 1", envelope.Version, len(envelope.Items), codec.EnvelopeVersion)
+	}
+
+	return envelope.Items[0].ID, envelope.Items[0].Data
+}
+
+// assertHeaders checks that an output carries its content type and continues
+// the trace of the consumed message
+func assertHeaders(t *testing.T, message MemoryMessage) {
+	t.Helper()
+
+	headers := make(map[string]string)
+	for _, header := range message.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	if contentType := (codec.JSON{}).ContentType(); headers["content-type"] != contentType {
+		t.Errorf("content-type header is %q, want %s", headers["content-type"], contentType)
+	}
+
+	traceID := strings.Split(traceParent, "-")[1]
+	if !strings.Contains(headers["traceparent"], traceID) {
+		t.Errorf("traceparent header %q does not continue trace %s", headers["traceparent"], traceID)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/influxdb"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"go.uber.org/zap"
+)
+
+func (e *Engine) startInfluxDBWriter() {
+	if !e.cfg.App.InfluxDB.Enabled {
+		e.verboseDebug("InfluxDB writer disabled")
+		return
+	}
+
+	e.logger.Info("Starting InfluxDB writer", zap.String("url", e.cfg.App.InfluxDB.URL), zap.String("bucket", e.cfg.App.InfluxDB.Bucket))
+
+	var influxDBLogger *zap.Logger
+	if flags.FlagWorkersLogging {
+		influxDBLogger = loglevel.Logger("influxdb")
+	} else {
+		influxDBLogger = zap.NewNop()
+	}
+
+	influxDBWriter, err := influxdb.NewWriter(e.ctx, &e.cfg.App.InfluxDB, influxDBLogger)
+	if err != nil {
+		e.logger.Error("Failed to create InfluxDB writer", zap.Error(err))
+		return
+	}
+
+	e.influxDBWriter = influxDBWriter
+}
@@ -3,11 +3,11 @@ package engine
 import (
 	"log"
 
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/johandrevandeventer/kafkaclient/config"
-	"github.com/johandrevandeventer/kafkaclient/consumer"
 	"github.com/johandrevandeventer/kafkaclient/producer"
-	"github.com/johandrevandeventer/logging"
 	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
 	"go.uber.org/zap"
 )
 
@@ -16,7 +16,7 @@ func (e *Engine) startKafkaProducer() {
 
 	var kafkaProducerLogger *zap.Logger
 	if flags.FlagKafkaLogging {
-		kafkaProducerLogger = logging.GetLogger("kafka.producer")
+		kafkaProducerLogger = loglevel.Logger("kafka.producer")
 	} else {
 		kafkaProducerLogger = zap.NewNop()
 	}
@@ -30,7 +30,31 @@ func (e *Engine) startKafkaProducer() {
 		log.Fatalf("Failed to create Kafka producer pool: %v", err)
 	}
 
-	e.kafkaProducerPool = kafkaProducerPool
+	// Delivery reports of messages sent with headers are handled once the
+	// spool is started, see startDeliveryReports
+	e.deliveryChan = make(chan kafka.Event, 10000)
+	e.deliveryReportsDone = make(chan struct{})
+	e.sink = &kafkaSink{
+		pool:         kafkaProducerPool,
+		deliveryChan: e.deliveryChan,
+		reportsDone:  e.deliveryReportsDone,
+		maxRetries:   producerConfig.MaxRetries,
+		logger:       kafkaProducerLogger,
+	}
+}
+
+// startDeliveryReports handles the delivery reports of the Kafka producer. The
+// reports are handled until the sink is closed rather than until the engine
+// stops, so failed deliveries of the messages flushed on close are spooled too.
+func (e *Engine) startDeliveryReports() {
+	if e.deliveryChan == nil {
+		return
+	}
+
+	go func() {
+		defer close(e.deliveryReportsDone)
+		e.handleDeliveryReports(e.deliveryChan)
+	}()
 }
 
 func (e *Engine) startKafkaConsumer() {
@@ -38,31 +62,37 @@ func (e *Engine) startKafkaConsumer() {
 
 	var kafkaConsumerLogger *zap.Logger
 	if flags.FlagKafkaLogging {
-		kafkaConsumerLogger = logging.GetLogger("kafka.consumer")
+		kafkaConsumerLogger = loglevel.Logger("kafka.consumer")
 	} else {
 		kafkaConsumerLogger = zap.NewNop()
 	}
 
 	// Define Kafka consumer config
-	var consumerConfig *config.KafkaConsumerConfig
-	if flags.FlagEnvironment == "development" {
-		consumerConfig = config.NewKafkaConsumerConfig("localhost:9092", "rubicon_kafka_mqtt_development", "mqtt-development-consumer-group")
-	} else {
-		consumerConfig = config.NewKafkaConsumerConfig("localhost:9092", "rubicon_kafka_mqtt", "mqtt-consumer-group")
-	}
+	stage := e.cfg.App.Kafka.ConsumerStage
+	consumerConfig := config.NewKafkaConsumerConfig("localhost:9092", e.kafkaTopic(stage), e.cfg.App.Kafka.Group(stage, flags.FlagEnvironment))
 
-	// Initialize Kafka Consumer Pool
-	kafkaConsumer, err := consumer.NewKafkaConsumer(e.ctx, consumerConfig, kafkaConsumerLogger)
+	// Initialize Kafka consumer
+	source, err := newKafkaSource(e.ctx, consumerConfig.Broker, consumerConfig.Topic, consumerConfig.GroupID, kafkaConsumerLogger)
 	if err != nil {
-		log.Fatalf("Failed to create Kafka consumer pool: %v", err)
+		log.Fatalf("Failed to create Kafka consumer: %v", err)
 	}
 
-	e.kafkaConsumer = kafkaConsumer
+	e.source = source
 
 	// Start Kafka consumer
+	e.startMessageSource()
+}
+
+// startMessageSource starts consuming from the message source
+func (e *Engine) startMessageSource() {
 	e.wg.Add(1)
 	go func() {
 		defer e.wg.Done()
-		e.kafkaConsumer.Start()
+		e.source.Start()
 	}()
 }
+
+// kafkaTopic returns the Kafka topic of a stage in the current environment
+func (e *Engine) kafkaTopic(stage string) string {
+	return e.cfg.App.Kafka.Topic(stage, flags.FlagEnvironment)
+}
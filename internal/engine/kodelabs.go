@@ -0,0 +1,18 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/kodelabs"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+// buildKodelabsMessage transforms processed data into a Kodelabs message
+func (e *Engine) buildKodelabsMessage(ds *types.DataStruct) (*kodelabs.Message, error) {
+	kodelabsMessage, err := e.kodelabsTransformer.Load().Transform(ds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform data: %w", err)
+	}
+
+	return kodelabsMessage, nil
+}
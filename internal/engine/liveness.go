@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/liveness"
+	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+	"go.uber.org/zap"
+)
+
+func (e *Engine) startLivenessTracker() {
+	livenessCfg := e.cfg.App.Liveness
+	if !livenessCfg.Enabled {
+		e.verboseDebug("Liveness tracking disabled")
+		return
+	}
+
+	e.logger.Info("Starting liveness tracker", zap.String("topic", e.livenessTopic()))
+
+	deviceTypeIntervals := make(map[string]time.Duration, len(livenessCfg.DeviceTypeIntervals))
+	for deviceType, interval := range livenessCfg.DeviceTypeIntervals {
+		deviceTypeIntervals[deviceType] = time.Duration(interval) * time.Second
+	}
+
+	e.livenessTracker = liveness.NewTracker(
+		time.Duration(livenessCfg.DefaultInterval)*time.Second,
+		deviceTypeIntervals,
+		livenessCfg.MissedIntervals,
+	)
+	e.restoreLiveness()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.runLivenessChecker()
+	}()
+}
+
+// restoreLiveness loads the liveness state persisted before the last restart
+func (e *Engine) restoreLiveness() {
+	data, err := os.ReadFile(e.cfg.App.Runtime.PersistFilePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			e.logger.Warn("Failed to read persisted liveness state", zap.Error(err))
+		}
+		return
+	}
+
+	var state struct {
+		Liveness *liveness.Snapshot `json:"liveness"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		e.logger.Warn("Failed to decode persisted liveness state", zap.Error(err))
+		return
+	}
+
+	if state.Liveness == nil {
+		return
+	}
+
+	e.livenessTracker.Restore(*state.Liveness)
+	e.verboseDebug("Liveness state restored", zap.Int("devices", len(state.Liveness.Devices)), zap.Int("controllers", len(state.Liveness.Controllers)))
+}
+
+// livenessTopic returns the Kafka topic liveness events are sent to
+func (e *Engine) livenessTopic() string {
+	return e.kafkaTopic(e.cfg.App.Liveness.Stage)
+}
+
+// runLivenessChecker periodically emits offline events for silent devices
+func (e *Engine) runLivenessChecker() {
+	interval := time.Duration(e.cfg.App.Liveness.CheckInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			// Devices are not heard while paused, they are re-armed on resume
+			if !e.paused.Load() {
+				e.publishLivenessEvents(e.livenessTracker.Check(time.Now()))
+			}
+			e.statePersister.Set("liveness", e.livenessTracker.Snapshot())
+		}
+	}
+}
+
+// publishLivenessEvents sends liveness events to Kafka
+func (e *Engine) publishLivenessEvents(events []liveness.Event) {
+	for _, event := range events {
+		e.logger.Info("Liveness changed",
+			zap.String("type", event.Type),
+			zap.String("kind", event.Kind),
+			zap.String("identifier", event.Identifier),
+			zap.String("customer", event.CustomerName),
+			zap.String("site", event.SiteName),
+		)
+
+		if e.sink == nil {
+			e.logger.Warn("Message sink not available, dropping liveness event", zap.String("identifier", event.Identifier))
+			continue
+		}
+
+		serializedEvent, err := json.Marshal(event)
+		if err != nil {
+			e.logger.Error("Failed to serialize liveness event", zap.Error(err))
+			continue
+		}
+
+		p := payload.Payload{
+			ID:               coreutils.GenerateUUID(),
+			Message:          serializedEvent,
+			MessageTimestamp: event.Timestamp,
+		}
+
+		serializedPayload, err := p.Serialize()
+		if err != nil {
+			e.logger.Error("Failed to serialize liveness payload", zap.Error(err))
+			continue
+		}
+
+		if err := e.sendMessage(e.ctx, e.livenessTopic(), serializedPayload); err != nil {
+			e.logger.Error("Failed to send liveness event to Kafka", zap.Error(err))
+			e.stats.recordError(errorClassProduce)
+			continue
+		}
+		e.stats.recordProduced()
+	}
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// MemorySource is an in-memory MessageSource. Messages passed to Publish are
+// delivered to the engine in order.
+type MemorySource struct {
+	messages  chan SourceMessage
+	paused    atomic.Bool
+	closeOnce sync.Once
+}
+
+// NewMemorySource creates a MemorySource that buffers up to size messages
+func NewMemorySource(size int) *MemorySource {
+	return &MemorySource{
+		messages: make(chan SourceMessage, size),
+	}
+}
+
+// Publish queues a serialized payload and its headers for the engine, blocking
+// while the buffer is full
+func (s *MemorySource) Publish(message []byte, headers ...kafka.Header) {
+	s.messages <- SourceMessage{Value: message, Headers: headers}
+}
+
+func (s *MemorySource) Start() {}
+
+func (s *MemorySource) Messages() <-chan SourceMessage {
+	return s.messages
+}
+
+// Pause marks the source as paused. Published messages stay buffered.
+func (s *MemorySource) Pause() {
+	s.paused.Store(true)
+}
+
+func (s *MemorySource) Resume() {
+	s.paused.Store(false)
+}
+
+// Paused reports whether the engine paused the source
+func (s *MemorySource) Paused() bool {
+	return s.paused.Load()
+}
+
+// Close closes the message channel, which stops the worker once the queued
+// messages have been handled
+func (s *MemorySource) Close() {
+	s.closeOnce.Do(func() {
+		close(s.messages)
+	})
+}
+
+// MemoryMessage is a message published to a MemorySink
+type MemoryMessage struct {
+	Topic   string
+	Value   []byte
+	Headers []kafka.Header
+}
+
+// MemorySink is an in-memory MessageSink that records every message per topic
+type MemorySink struct {
+	mu       sync.Mutex
+	messages map[string][]MemoryMessage
+}
+
+// NewMemorySink creates an empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		messages: make(map[string][]MemoryMessage),
+	}
+}
+
+func (s *MemorySink) Send(ctx context.Context, topic string, message []byte, headers []kafka.Header) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages[topic] = append(s.messages[topic], MemoryMessage{
+		Topic:   topic,
+		Value:   append([]byte(nil), message...),
+		Headers: append([]kafka.Header(nil), headers...),
+	})
+
+	return nil
+}
+
+func (s *MemorySink) Close() {}
+
+// Messages returns the messages published to a topic, in order
+func (s *MemorySink) Messages(topic string) []MemoryMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]MemoryMessage(nil), s.messages[topic]...)
+}
+
+// Topics returns the topics messages were published to
+func (s *MemorySink) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := make([]string, 0, len(s.messages))
+	for topic := range s.messages {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	return topics
+}
+
+// Reset removes all recorded messages
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = make(map[string][]MemoryMessage)
+}
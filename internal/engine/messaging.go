@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/kafkaclient/producer"
+	"go.uber.org/zap"
+)
+
+// SourceMessage is a consumed message: a serialized payload and the headers it
+// was received with
+type SourceMessage struct {
+	Value   []byte
+	Headers []kafka.Header
+}
+
+// MessageSource delivers the serialized payloads the engine works on
+type MessageSource interface {
+	// Start consumes messages until the source is closed
+	Start()
+	// Messages returns the channel consumed messages are delivered on. The
+	// channel is closed when the source stops.
+	Messages() <-chan SourceMessage
+	// Pause stops the source from fetching new messages until Resume is
+	// called. Messages already fetched may still be delivered.
+	Pause()
+	Resume()
+	Close()
+}
+
+// MessageSink publishes serialized payloads to topics
+type MessageSink interface {
+	Send(ctx context.Context, topic string, message []byte, headers []kafka.Header) error
+	Close()
+}
+
+// sinkChecker is implemented by sinks that can check whether they are
+// reachable
+type sinkChecker interface {
+	Check(ctx context.Context) error
+}
+
+// Kafka source settings
+const (
+	sourcePollTimeout = 100 * time.Millisecond
+	sourceChannelSize = 1000
+)
+
+// kafkaSource is a MessageSource backed by a Kafka consumer. The kafkaclient
+// consumer drops message headers, so the Kafka client is used directly.
+type kafkaSource struct {
+	ctx      context.Context
+	logger   *zap.Logger
+	consumer *kafka.Consumer
+	topic    string
+	messages chan SourceMessage
+	paused   atomic.Bool
+
+	// partitionsPaused is only used by the poll loop and the rebalance
+	// callback, which runs inside Poll
+	partitionsPaused bool
+}
+
+func newKafkaSource(ctx context.Context, broker string, topic string, groupID string, logger *zap.Logger) (*kafkaSource, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": broker,
+		"log_level":         0,
+		"group.id":          groupID,
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Kafka consumer created successfully")
+
+	return &kafkaSource{
+		ctx:      ctx,
+		logger:   logger,
+		consumer: consumer,
+		topic:    topic,
+		messages: make(chan SourceMessage, sourceChannelSize),
+	}, nil
+}
+
+// Start polls the topic until the context is cancelled. While the source is
+// paused, or messages the channel has no room for are held back, the assigned
+// partitions are paused instead of no longer polling, so that the consumer
+// stays in its group.
+func (s *kafkaSource) Start() {
+	if err := s.consumer.SubscribeTopics([]string{s.topic}, s.rebalance); err != nil {
+		s.logger.Error("Failed to subscribe to Kafka topic", zap.String("topic", s.topic), zap.Error(err))
+		return
+	}
+
+	s.logger.Info("Successfully subscribed to Kafka topics", zap.Strings("topics", []string{s.topic}))
+
+	var backlog []SourceMessage
+	for {
+		if s.ctx.Err() != nil {
+			s.logger.Info("Stopping message consumption")
+			return
+		}
+
+	send:
+		for len(backlog) > 0 {
+			select {
+			case s.messages <- backlog[0]:
+				backlog = backlog[1:]
+			default:
+				break send
+			}
+		}
+
+		s.setPartitionsPaused(len(backlog) > 0 || s.paused.Load())
+
+		switch ev := s.consumer.Poll(int(sourcePollTimeout.Milliseconds())).(type) {
+		case *kafka.Message:
+			s.logger.Debug("Received message",
+				zap.String("kafka_topic", *ev.TopicPartition.Topic),
+				zap.Int32("partition", ev.TopicPartition.Partition),
+				zap.Int64("offset", int64(ev.TopicPartition.Offset)),
+			)
+			backlog = append(backlog, SourceMessage{Value: ev.Value, Headers: ev.Headers})
+		case kafka.Error:
+			s.logger.Error("Kafka error", zap.Error(ev))
+		}
+	}
+}
+
+// setPartitionsPaused pauses or resumes fetching from the assigned partitions
+func (s *kafkaSource) setPartitionsPaused(paused bool) {
+	if s.partitionsPaused == paused {
+		return
+	}
+
+	partitions, err := s.consumer.Assignment()
+	if err != nil {
+		s.logger.Error("Failed to get assigned partitions", zap.Error(err))
+		return
+	}
+
+	if paused {
+		err = s.consumer.Pause(partitions)
+	} else {
+		err = s.consumer.Resume(partitions)
+	}
+	if err != nil {
+		s.logger.Error("Failed to pause or resume partitions", zap.Bool("paused", paused), zap.Error(err))
+		return
+	}
+
+	s.partitionsPaused = paused
+	s.logger.Debug("Kafka partitions paused", zap.Bool("paused", paused))
+}
+
+// rebalance keeps partitions that are assigned while paused paused
+func (s *kafkaSource) rebalance(c *kafka.Consumer, ev kafka.Event) error {
+	assigned, ok := ev.(kafka.AssignedPartitions)
+	if !ok || !s.partitionsPaused {
+		return nil
+	}
+
+	if err := c.Assign(assigned.Partitions); err != nil {
+		return err
+	}
+
+	return c.Pause(assigned.Partitions)
+}
+
+func (s *kafkaSource) Messages() <-chan SourceMessage {
+	return s.messages
+}
+
+// Pause pauses the assigned partitions on the next poll
+func (s *kafkaSource) Pause() {
+	s.paused.Store(true)
+}
+
+// Resume resumes the assigned partitions on the next poll
+func (s *kafkaSource) Resume() {
+	s.paused.Store(false)
+}
+
+func (s *kafkaSource) Close() {
+	s.logger.Info("Closing Kafka consumer...")
+
+	if err := s.consumer.Close(); err != nil {
+		s.logger.Error("Failed to close Kafka consumer", zap.Error(err))
+	}
+	close(s.messages)
+}
+
+// kafkaSink is a MessageSink backed by the Kafka producer pool. The pool's
+// SendMessage does not support headers, so a producer is borrowed from the
+// pool and delivery reports are sent to deliveryChan. Like SendMessage, a
+// failed produce is retried with exponential backoff up to maxRetries times.
+// reportsDone is closed once the delivery reports have been handled.
+type kafkaSink struct {
+	pool         *producer.KafkaProducerPool
+	deliveryChan chan kafka.Event
+	reportsDone  chan struct{}
+	maxRetries   int
+	logger       *zap.Logger
+}
+
+func (s *kafkaSink) Send(ctx context.Context, topic string, message []byte, headers []kafka.Header) error {
+	producer := s.pool.Get()
+	defer s.pool.Put(producer)
+
+	operation := func() error {
+		return producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Value:          message,
+			Headers:        headers,
+		}, s.deliveryChan)
+	}
+
+	retryBackoff := backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(s.maxRetries))
+	err := backoff.RetryNotify(operation, backoff.WithContext(retryBackoff, ctx), func(err error, duration time.Duration) {
+		s.logger.Warn("Failed to send message, retrying...", zap.String("kafka_topic", topic), zap.Error(err), zap.Duration("retry_after", duration))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to produce message to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+// Check requests the cluster metadata to check whether a broker is reachable
+func (s *kafkaSink) Check(ctx context.Context) error {
+	producer := s.pool.Get()
+	defer s.pool.Put(producer)
+
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	if _, err := producer.GetMetadata(nil, false, int(timeout.Milliseconds())); err != nil {
+		return fmt.Errorf("failed to reach Kafka: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes and closes the producers, then waits for the delivery reports
+// of the flushed messages to be handled
+func (s *kafkaSink) Close() {
+	s.pool.Close()
+
+	// The producers are closed, nothing sends to the channel anymore
+	close(s.deliveryChan)
+	<-s.reportsDone
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// headerMessageID is the Kafka header that carries the ID of the consumed
+// message an output was produced from. A batch carries the comma separated IDs
+// of the messages of its outputs, in order.
+const headerMessageID = "message_id"
+
+// messageIDHeader returns the Kafka header for a consumed message ID
+func messageIDHeader(id string) kafka.Header {
+	return kafka.Header{Key: headerMessageID, Value: []byte(id)}
+}
+
+// sendMessage sends a serialized payload to a topic of the message sink with
+// headers and the trace context of ctx
+func (e *Engine) sendMessage(ctx context.Context, topic string, message []byte, headers ...kafka.Header) (err error) {
+	ctx, span := tracing.Start(ctx, "send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", topic),
+			attribute.Int("messaging.message.body.size", len(message)),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	return e.sink.Send(ctx, topic, message, tracing.Inject(ctx, append([]kafka.Header(nil), headers...)))
+}
+
+// handleDeliveryReports logs failed deliveries of messages sent to the Kafka
+// sink until deliveryChan is closed
+func (e *Engine) handleDeliveryReports(deliveryChan <-chan kafka.Event) {
+	var kafkaProducerLogger *zap.Logger
+	if flags.FlagKafkaLogging {
+		kafkaProducerLogger = loglevel.Logger("kafka.producer")
+	} else {
+		kafkaProducerLogger = zap.NewNop()
+	}
+
+	for event := range deliveryChan {
+		msg, ok := event.(*kafka.Message)
+		if !ok {
+			continue
+		}
+
+		fields := []zap.Field{zap.String("kafka_topic", *msg.TopicPartition.Topic)}
+		for _, header := range msg.Headers {
+			if header.Key == headerMessageID {
+				fields = append(fields, zap.String("id", string(header.Value)))
+			}
+		}
+
+		if msg.TopicPartition.Error != nil {
+			kafkaProducerLogger.Error("Failed to deliver message", append(fields, zap.Error(msg.TopicPartition.Error))...)
+			e.stats.recordError(errorClassProduce)
+			if e.spoolSink != nil {
+				if err := e.spoolSink.deliveryFailed(msg); err != nil {
+					kafkaProducerLogger.Error("Failed to spool undelivered message", append(fields, zap.Error(err))...)
+					e.stats.recordError(errorClassSpool)
+				}
+			}
+			continue
+		}
+
+		kafkaProducerLogger.Debug("Message delivered", append(fields, zap.Int64("offset", int64(msg.TopicPartition.Offset)))...)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package engine
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals runs the control commands bound to process signals. SIGHUP
+// reloads the configuration and the ignore list, SIGUSR1 pauses and SIGUSR2
+// resumes consumption.
+func (e *Engine) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.stopFileChan:
+			return
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGHUP:
+				e.RunCommand(CommandReloadConfig, "", "SIGHUP")
+				e.RunCommand(CommandReloadIgnoreList, "", "SIGHUP")
+			case syscall.SIGUSR1:
+				e.RunCommand(CommandPause, "", "SIGUSR1")
+			case syscall.SIGUSR2:
+				e.RunCommand(CommandResume, "", "SIGUSR2")
+			}
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package engine
+
+// watchSignals is a no-op on Windows, which has no SIGHUP, SIGUSR1 or SIGUSR2.
+// Use the control directory instead.
+func (e *Engine) watchSignals() {}
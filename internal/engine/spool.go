@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/spool"
+	"go.uber.org/zap"
+)
+
+// spoolReplayBatchSize is the number of records replayed between sends of new
+// messages
+const spoolReplayBatchSize = 1000
+
+// spoolSink is a MessageSink that writes messages to the disk spool while the
+// wrapped sink is unavailable, and replays them in order once it recovers.
+// While the spool holds records, new messages are appended to it as well so
+// they are not sent ahead of older ones.
+type spoolSink struct {
+	sink    MessageSink
+	spool   *spool.Spool
+	logger  *zap.Logger
+	mu      sync.Mutex
+	healthy atomic.Bool
+}
+
+// Send sends a message to the wrapped sink, or appends it to the spool if the
+// sink is unavailable or the spool holds records. The lock is only held to
+// decide, not across the wrapped sink's retries.
+func (s *spoolSink) Send(ctx context.Context, topic string, message []byte, headers []kafka.Header) error {
+	s.mu.Lock()
+	direct := s.healthy.Load() && s.spool.Len() == 0
+	s.mu.Unlock()
+
+	if direct {
+		err := s.sink.Send(ctx, topic, message, headers)
+		if err == nil {
+			return nil
+		}
+
+		s.logger.Warn("Failed to send message, spooling to disk", zap.String("topic", topic), zap.Error(err))
+		s.healthy.Store(false)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.spool.Append(spool.Record{Time: time.Now(), Topic: topic, Value: message, Headers: headers})
+}
+
+// deliveryFailed spools a message the wrapped sink accepted but could not
+// deliver. It is appended after the messages spooled since it was sent.
+func (s *spoolSink) deliveryFailed(msg *kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.healthy.Store(false)
+
+	return s.spool.Append(spool.Record{Time: time.Now(), Topic: *msg.TopicPartition.Topic, Value: msg.Value, Headers: msg.Headers})
+}
+
+// replay sends the spooled messages to the wrapped sink until the spool is
+// empty or a send fails
+func (s *spoolSink) replay(ctx context.Context) {
+	s.spool.Expire(time.Now())
+
+	if s.spool.Len() == 0 {
+		return
+	}
+
+	if !s.healthy.Load() {
+		if checker, ok := s.sink.(sinkChecker); ok {
+			if err := checker.Check(ctx); err != nil {
+				s.logger.Debug("Message sink still unavailable", zap.Int("spooled", s.spool.Len()), zap.Error(err))
+				return
+			}
+		}
+
+		s.logger.Info("Message sink available, replaying spool", zap.Int("spooled", s.spool.Len()))
+		s.healthy.Store(true)
+	}
+
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		replayed, err := s.spool.Replay(spoolReplayBatchSize, func(record spool.Record) error {
+			return s.sink.Send(ctx, record.Topic, record.Value, record.Headers)
+		})
+		remaining := s.spool.Len()
+		s.mu.Unlock()
+
+		if err != nil {
+			s.logger.Warn("Failed to replay spooled message", zap.Int("replayed", replayed), zap.Int("spooled", remaining), zap.Error(err))
+			s.healthy.Store(false)
+			return
+		}
+
+		if remaining == 0 {
+			s.logger.Info("Spool replayed")
+			return
+		}
+	}
+}
+
+func (s *spoolSink) Close() {
+	s.sink.Close()
+	s.spool.Close()
+}
+
+// startSpool wraps the message sink in the disk spool if it is enabled
+func (e *Engine) startSpool() {
+	if !e.cfg.App.Spool.Enabled {
+		e.verboseDebug("Spool disabled")
+		return
+	}
+
+	if e.sink == nil {
+		e.logger.Warn("Message sink not available, spool disabled")
+		return
+	}
+
+	logger := loglevel.Logger("spool")
+
+	s, err := spool.Open(&e.cfg.App.Spool, logger)
+	if err != nil {
+		e.logger.Error("Failed to open spool", zap.Error(err))
+		return
+	}
+
+	e.logger.Info("Starting spool", zap.String("dir", e.cfg.App.Spool.Dir), zap.Int("spooled", s.Len()))
+
+	sink := &spoolSink{sink: e.sink, spool: s, logger: logger}
+	sink.healthy.Store(true)
+	e.spoolSink = sink
+	e.sink = sink
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.runSpoolReplay()
+	}()
+}
+
+// runSpoolReplay replays the spool by replay interval until the engine stops
+func (e *Engine) runSpoolReplay() {
+	ticker := time.NewTicker(time.Duration(e.cfg.App.Spool.ReplayInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.stopFileChan:
+			return
+		case <-ticker.C:
+			e.spoolSink.replay(e.ctx)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"github.com/johandrevandeventer/mqtt-worker/internal/spool"
+	"go.uber.org/zap"
+)
+
+var errSinkUnavailable = errors.New("sink unavailable")
+
+// failingSink is a MemorySink that fails every send and check while down
+type failingSink struct {
+	*MemorySink
+	down atomic.Bool
+}
+
+func (s *failingSink) Send(ctx context.Context, topic string, message []byte, headers []kafka.Header) error {
+	if s.down.Load() {
+		return errSinkUnavailable
+	}
+
+	return s.MemorySink.Send(ctx, topic, message, headers)
+}
+
+func (s *failingSink) Check(ctx context.Context) error {
+	if s.down.Load() {
+		return errSinkUnavailable
+	}
+
+	return nil
+}
+
+func newTestSpoolSink(t *testing.T) (*spoolSink, *failingSink) {
+	t.Helper()
+
+	s, err := spool.Open(&app.SpoolConfig{Dir: t.TempDir(), MaxSizeMB: 256, SegmentSizeMB: 8, MaxAge: 24}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped := &failingSink{MemorySink: NewMemorySink()}
+	sink := &spoolSink{sink: wrapped, spool: s, logger: zap.NewNop()}
+	sink.healthy.Store(true)
+	t.Cleanup(sink.Close)
+
+	return sink, wrapped
+}
+
+func sendMessages(t *testing.T, sink MessageSink, from, to int) {
+	t.Helper()
+
+	for i := from; i <= to; i++ {
+		if err := sink.Send(context.Background(), "output", []byte(fmt.Sprintf("message %d", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertMessages(t *testing.T, sink *MemorySink, from, to int) {
+	t.Helper()
+
+	var got, want []string
+	for _, message := range sink.Messages("output") {
+		got = append(got, string(message.Value))
+	}
+	for i := from; i <= to; i++ {
+		want = append(want, fmt.Sprintf("message %d", i))
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sink received %q, want %q", got, want)
+	}
+}
+
+func TestSpoolSinkSpoolsWhileSinkIsDown(t *testing.T) {
+	sink, wrapped := newTestSpoolSink(t)
+	ctx := context.Background()
+
+	sendMessages(t, sink, 1, 2)
+	assertMessages(t, wrapped.MemorySink, 1, 2)
+
+	wrapped.down.Store(true)
+	sendMessages(t, sink, 3, 4)
+
+	if sink.healthy.Load() || sink.spool.Len() != 2 {
+		t.Fatalf("healthy %t with %d messages spooled, want unhealthy with 2", sink.healthy.Load(), sink.spool.Len())
+	}
+
+	// Nothing is replayed while the sink check fails
+	sink.replay(ctx)
+	if sink.spool.Len() != 2 {
+		t.Fatalf("%d messages spooled after a failed replay, want 2", sink.spool.Len())
+	}
+
+	// New messages are spooled behind the older ones once the sink is back
+	wrapped.down.Store(false)
+	sendMessages(t, sink, 5, 5)
+	if sink.spool.Len() != 3 {
+		t.Fatalf("%d messages spooled, want 3", sink.spool.Len())
+	}
+
+	sink.replay(ctx)
+	if !sink.healthy.Load() || sink.spool.Len() != 0 {
+		t.Fatalf("healthy %t with %d messages spooled after replay, want healthy with none", sink.healthy.Load(), sink.spool.Len())
+	}
+
+	sendMessages(t, sink, 6, 6)
+	assertMessages(t, wrapped.MemorySink, 1, 6)
+}
+
+func TestSpoolSinkDeliveryFailed(t *testing.T) {
+	sink, wrapped := newTestSpoolSink(t)
+
+	topic := "output"
+	if err := sink.deliveryFailed(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte("message 1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages sent after an undelivered one wait behind it in the spool
+	sendMessages(t, sink, 2, 2)
+	if sink.healthy.Load() || sink.spool.Len() != 2 {
+		t.Fatalf("healthy %t with %d messages spooled, want unhealthy with 2", sink.healthy.Load(), sink.spool.Len())
+	}
+
+	sink.replay(context.Background())
+	assertMessages(t, wrapped.MemorySink, 1, 2)
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Error classes recorded in the runtime statistics
+const (
+	errorClassDeserialize       = "deserialize"
+	errorClassControllerIgnored = "controller_ignored"
+	errorClassDeviceIgnored     = "device_ignored"
+	errorClassDeviceNotFound    = "device_not_found"
+	errorClassOwnership         = "ownership"
+	errorClassProcessing        = "processing"
+	errorClassSerialize         = "serialize"
+	errorClassProduce           = "produce"
+	errorClassInfluxDB          = "influxdb"
+	errorClassKodelabs          = "kodelabs"
+	errorClassArchive           = "archive"
+	errorClassSpool             = "spool"
+)
+
+// runtimeStats holds counters that are periodically flushed to the state persister
+type runtimeStats struct {
+	mu                    sync.Mutex
+	messagesConsumed      uint64
+	messagesDecoded       uint64
+	messagesProduced      uint64
+	batchesProduced       uint64
+	errors                map[string]uint64
+	lastMessageTime       time.Time
+	lastMessageByCustomer map[string]time.Time
+}
+
+func newRuntimeStats() *runtimeStats {
+	return &runtimeStats{
+		errors:                make(map[string]uint64),
+		lastMessageByCustomer: make(map[string]time.Time),
+	}
+}
+
+// recordConsumed records a message taken from the Kafka consumer
+func (s *runtimeStats) recordConsumed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesConsumed++
+	s.lastMessageTime = time.Now()
+}
+
+// recordDecoded records a message that was decoded and processed for a customer
+func (s *runtimeStats) recordDecoded(customer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesDecoded++
+	if customer != "" {
+		s.lastMessageByCustomer[customer] = time.Now()
+	}
+}
+
+// recordProduced records a message sent to the Kafka producer
+func (s *runtimeStats) recordProduced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesProduced++
+}
+
+// recordProducedBatch records a batch of outputs sent to the Kafka producer
+func (s *runtimeStats) recordProducedBatch(count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesProduced += uint64(count)
+	s.batchesProduced++
+}
+
+// recordError records an error of the given class
+func (s *runtimeStats) recordError(class string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[class]++
+}
+
+// snapshot returns the statistics in the shape they are persisted in
+func (s *runtimeStats) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lastMessageByCustomer := make(map[string]string, len(s.lastMessageByCustomer))
+	for customer, t := range s.lastMessageByCustomer {
+		lastMessageByCustomer[customer] = t.Format(time.RFC3339)
+	}
+
+	var lastMessageTime string
+	if !s.lastMessageTime.IsZero() {
+		lastMessageTime = s.lastMessageTime.Format(time.RFC3339)
+	}
+
+	return map[string]any{
+		"messages_consumed":        s.messagesConsumed,
+		"messages_decoded":         s.messagesDecoded,
+		"messages_produced":        s.messagesProduced,
+		"batches_produced":         s.batchesProduced,
+		"errors":                   maps.Clone(s.errors),
+		"last_message_time":        lastMessageTime,
+		"last_message_by_customer": lastMessageByCustomer,
+		"updated_at":               time.Now().Format(time.RFC3339),
+	}
+}
+
+// flushStats writes the current statistics to the state persister
+func (e *Engine) flushStats() {
+	e.statePersister.Set("stats", e.stats.snapshot())
+
+	if e.spoolSink != nil {
+		e.statePersister.Set("spool", e.spoolSink.spool.Stats())
+	}
+}
+
+// runStatsFlusher periodically flushes the statistics until the engine stops
+func (e *Engine) runStatsFlusher() {
+	interval := time.Duration(e.cfg.App.Stats.FlushInterval) * time.Second
+	if interval <= 0 {
+		e.verboseDebug("Runtime statistics flushing disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.stopFileChan:
+			return
+		case <-ticker.C:
+			e.flushStats()
+		}
+	}
+}
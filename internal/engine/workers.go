@@ -1,14 +1,20 @@
 package engine
 
 import (
-	"encoding/json"
-	"strings"
+	"errors"
+	"time"
 
 	"github.com/johandrevandeventer/kafkaclient/payload"
-	"github.com/johandrevandeventer/logging"
+	"github.com/johandrevandeventer/mqtt-worker/internal/codec"
 	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/influxdb"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
 	mqttworker "github.com/johandrevandeventer/mqtt-worker/internal/workers/mqtt_worker"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -18,149 +24,191 @@ func (e *Engine) startWorker() {
 	var workersLogger *zap.Logger
 	var kafkaProducerLogger *zap.Logger
 	if flags.FlagWorkersLogging {
-		workersLogger = logging.GetLogger("workers")
-		kafkaProducerLogger = logging.GetLogger("kafka.producer")
+		workersLogger = loglevel.Logger("workers")
+		kafkaProducerLogger = loglevel.Logger("kafka.producer")
 	} else {
 		workersLogger = zap.NewNop()
 		kafkaProducerLogger = zap.NewNop()
 	}
 
+	// The topics may be reloaded and the engine paused by control commands
+	// at any time, so both are read under the control lock
+	e.controlMu.Lock()
+	topicMatcher, err := workers.NewTopicMatcher(e.cfg.App.Topics.Templates, e.cfg.App.Topics.CustomerAliases)
+	if err != nil {
+		e.controlMu.Unlock()
+		e.logger.Error("Failed to create topic matcher", zap.Error(err))
+		return
+	}
+	e.topicMatcher.Store(topicMatcher)
+
+	// The engine may have been paused before the source was started
+	if e.paused.Load() {
+		e.source.Pause()
+	}
+	e.controlMu.Unlock()
+
 	for {
+		// Stop taking messages while paused. The source pauses fetching too,
+		// but may still deliver messages it had already fetched.
+		input := e.source.Messages()
+		if e.paused.Load() {
+			input = nil
+		}
+
 		select {
 		case <-e.ctx.Done(): // Handle context cancellation (e.g., Ctrl+C)
 			e.logger.Info("Stopping worker due to context cancellation")
 			return
-		case data, ok := <-e.kafkaConsumer.GetOutputChannel():
+		case <-e.pauseChanged:
+			continue
+		case message, ok := <-input:
 			if !ok { // Channel is closed
-				e.logger.Info("Kafka consumer output channel closed, stopping worker")
+				e.logger.Info("Message source closed, stopping worker")
 				return
 			}
 
-			deserializedData, err := payload.Deserialize(data)
-			if err != nil {
-				e.logger.Error("Failed to deserialize data", zap.Error(err))
-				continue
-			}
-
-			worker := mqttworker.NewWorker(workersLogger)
-
-			messageInfo, err := worker.RunWorker(data)
-			if err != nil {
-				if strings.Contains(err.Error(), "controller is ignored") {
-					errorSplit := strings.Split(err.Error(), "controller is ignored: ")
-					controllerID := errorSplit[1]
-					e.logger.Warn("Controller is ignored", zap.String("controllerID", controllerID))
-				} else if strings.Contains(err.Error(), "device is ignored") {
-					errorSplit := strings.Split(err.Error(), "device is ignored: ")
-					deviceID := errorSplit[1]
-					e.logger.Warn("Device is ignored", zap.String("deviceID", deviceID))
-				} else if strings.Contains(err.Error(), "device not found") {
-					errorSplit := strings.Split(err.Error(), "device not found: ")
-					errorSplit = strings.Split(errorSplit[1], " -> ")
-					siteName := errorSplit[0]
-					deviceName := errorSplit[1]
-					deviceID := errorSplit[2]
-					e.logger.Warn("Device not found", zap.String("siteName", siteName), zap.String("deviceName", deviceName), zap.String("deviceID", deviceID))
-				} else {
-					e.logger.Error("Processing failed", zap.Error(err))
-				}
-				continue
+			if e.handleMessage(message, workersLogger, kafkaProducerLogger) {
+				return
 			}
+		}
+	}
+}
 
-			for _, device := range messageInfo.Devices {
-				rawDataStruct := &types.DataStruct{
-					State:                "Pre",
-					CustomerID:           device.CustomerID,
-					CustomerName:         device.CustomerName,
-					SiteID:               device.SiteID,
-					SiteName:             device.SiteName,
-					Controller:           device.Controller,
-					DeviceType:           device.DeviceType,
-					ControllerIdentifier: device.ControllerIdentifier,
-					DeviceName:           device.DeviceName,
-					DeviceIdentifier:     device.DeviceIdentifier,
-					Data:                 device.RawData,
-					Timestamp:            device.Timestamp,
-				}
-
-				processedDataStruct := &types.DataStruct{
-					State:                "Post",
-					CustomerID:           device.CustomerID,
-					CustomerName:         device.CustomerName,
-					SiteID:               device.SiteID,
-					SiteName:             device.SiteName,
-					Controller:           device.Controller,
-					DeviceType:           device.DeviceType,
-					ControllerIdentifier: device.ControllerIdentifier,
-					DeviceName:           device.DeviceName,
-					DeviceIdentifier:     device.DeviceIdentifier,
-					Data:                 device.ProcessedData,
-					Timestamp:            device.Timestamp,
-				}
+// handleMessage decodes and processes a consumed message and sends the outputs.
+// It returns true if the worker should stop.
+func (e *Engine) handleMessage(message SourceMessage, workersLogger *zap.Logger, kafkaProducerLogger *zap.Logger) (stop bool) {
+	e.stats.recordConsumed()
+
+	data := message.Value
+
+	// Continue the trace of the producer if the message carries one
+	ctx := tracing.Extract(e.ctx, message.Headers)
+	ctx, span := tracing.Start(ctx, "consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.system", "kafka")),
+	)
+	var consumeErr error
+	defer func() { tracing.End(span, consumeErr) }()
+
+	deserializedData, err := payload.Deserialize(data)
+	if err != nil {
+		e.logger.Error("Failed to deserialize data", zap.Error(err))
+		e.stats.recordError(errorClassDeserialize)
+		consumeErr = err
+		return false
+	}
 
-				serializedRawData, err := json.Marshal(rawDataStruct)
-				if err != nil {
-					workersLogger.Error("Failed to serialize raw data", zap.Error(err))
-					return
-				}
+	span.SetAttributes(
+		attribute.String("messaging.message.id", deserializedData.ID.String()),
+		attribute.String("mqtt.topic", deserializedData.MqttTopic),
+	)
+
+	// Correlate every log line and output of this message by its payload ID
+	msgCtx := workers.NewMessageContext(deserializedData.ID.String())
+	msgLogger := msgCtx.Logger(e.logger)
+	msgWorkersLogger := msgCtx.Logger(workersLogger)
+	msgProducerLogger := msgCtx.Logger(kafkaProducerLogger)
+
+	// Archived once the worker has resolved the customer
+	defer e.archiveMessage(data, msgCtx)
+
+	worker := mqttworker.NewWorker(workersLogger, e.topicMatcher.Load(), e.devices)
+
+	messageInfo, err := worker.RunWorker(ctx, data, msgCtx)
+	if err != nil {
+		consumeErr = err
+		var deviceNotFoundErr *workers.DeviceNotFoundError
+		var ignoredErr *workers.IgnoredError
+		var ownershipErr *workers.OwnershipError
+		if errors.As(err, &deviceNotFoundErr) {
+			msgLogger.Warn("Device not found", zap.String("siteName", deviceNotFoundErr.SiteName), zap.String("deviceName", deviceNotFoundErr.DeviceName), zap.String("deviceID", deviceNotFoundErr.DeviceIdentifier))
+			e.stats.recordError(errorClassDeviceNotFound)
+			e.recordUnknownDevice(ctx, deviceNotFoundErr, deserializedData.Message, msgCtx)
+		} else if errors.As(err, &ignoredErr) {
+			ignoredFields := []zap.Field{
+				zap.String("ruleID", ignoredErr.Rule.ID),
+				zap.String("reason", ignoredErr.Rule.Reason),
+				zap.String("owner", ignoredErr.Rule.Owner),
+			}
+			if ignoredErr.Kind == types.IgnoreKindController {
+				msgLogger.Warn("Controller is ignored", append(ignoredFields, zap.String("controllerID", ignoredErr.Identifier))...)
+				e.stats.recordError(errorClassControllerIgnored)
+			} else {
+				msgLogger.Warn("Device is ignored", append(ignoredFields, zap.String("deviceID", ignoredErr.Identifier))...)
+				e.stats.recordError(errorClassDeviceIgnored)
+			}
+		} else if errors.As(err, &ownershipErr) {
+			msgLogger.Warn("Device ownership mismatch", zap.String("deviceID", ownershipErr.DeviceIdentifier), zap.String("field", ownershipErr.Field), zap.String("expected", ownershipErr.Expected), zap.String("actual", ownershipErr.Actual), zap.String("topic", deserializedData.MqttTopic))
+			e.stats.recordError(errorClassOwnership)
+		} else {
+			msgLogger.Error("Processing failed", zap.Error(err))
+			e.stats.recordError(errorClassProcessing)
+		}
+		return false
+	}
 
-				serializedProcessedData, err := json.Marshal(processedDataStruct)
-				if err != nil {
-					workersLogger.Error("Failed to serialize processed data", zap.Error(err))
-					return
-				}
+	var customer string
+	if len(messageInfo.Devices) > 0 {
+		customer = messageInfo.Devices[0].CustomerName
+	}
+	e.stats.recordDecoded(customer)
 
-				rp := payload.Payload{
-					ID:               deserializedData.ID,
-					Message:          serializedRawData,
-					MessageTimestamp: rawDataStruct.Timestamp,
-				}
+	for _, device := range messageInfo.Devices {
+		msgCtx.DeviceIdentifier = device.DeviceIdentifier
+		if e.livenessTracker != nil {
+			e.publishLivenessEvents(e.livenessTracker.Seen(device, time.Now()))
+		}
 
-				pp := payload.Payload{
-					ID:               deserializedData.ID,
-					Message:          serializedProcessedData,
-					MessageTimestamp: processedDataStruct.Timestamp,
-				}
+		rawDataStruct := device.RawDataStruct()
+		processedDataStruct := device.ProcessedDataStruct()
 
-				serializedRp, err := rp.Serialize()
-				if err != nil {
-					workersLogger.Error("Failed to serialize raw payload", zap.Error(err))
-					return
+		// Write directly to InfluxDB if the sink is enabled
+		if e.influxDBWriter != nil {
+			if err := e.influxDBWriter.Write(rawDataStruct); err != nil {
+				e.stats.recordError(errorClassInfluxDB)
+				if !errors.Is(err, influxdb.ErrQueueFull) {
+					msgWorkersLogger.Error("Failed to write raw data to InfluxDB", zap.Error(err))
 				}
+			}
 
-				serializedPp, err := pp.Serialize()
-				if err != nil {
-					workersLogger.Error("Failed to serialize processed payload", zap.Error(err))
-					return
+			if err := e.influxDBWriter.Write(processedDataStruct); err != nil {
+				e.stats.recordError(errorClassInfluxDB)
+				if !errors.Is(err, influxdb.ErrQueueFull) {
+					msgWorkersLogger.Error("Failed to write processed data to InfluxDB", zap.Error(err))
 				}
+			}
+		}
 
-				influxdb_kafka_topic := "rubicon_kafka_influxdb"
-				kodelabs_kafka_topic := "rubicon_kafka_kodelabs"
+		influxdb_kafka_topic := e.kafkaTopic(e.cfg.App.Kafka.InfluxDBStage)
+		kodelabs_kafka_topic := e.kafkaTopic(e.cfg.App.Kafka.KodelabsStage)
 
-				if flags.FlagEnvironment == "development" {
-					influxdb_kafka_topic = "rubicon_kafka_influxdb_development"
-					kodelabs_kafka_topic = "rubicon_kafka_kodelabs_development"
-				}
+		// Send the processed data to the Kafka producer
+		err = e.sendOutput(ctx, influxdb_kafka_topic, codec.Record{ID: deserializedData.ID, Timestamp: rawDataStruct.Timestamp, Data: rawDataStruct})
+		if err != nil {
+			msgProducerLogger.Error("Failed to send raw data to Kafka", zap.Error(err))
+			return true
+		}
 
-				// Send the processed data to the Kafka producer
-				err = e.kafkaProducerPool.SendMessage(e.ctx, influxdb_kafka_topic, serializedRp)
-				if err != nil {
-					kafkaProducerLogger.Error("Failed to send raw data to Kafka", zap.Error(err))
-					return
-				}
+		err = e.sendOutput(ctx, influxdb_kafka_topic, codec.Record{ID: deserializedData.ID, Timestamp: processedDataStruct.Timestamp, Data: processedDataStruct})
+		if err != nil {
+			msgProducerLogger.Error("Failed to send processed data to Kafka", zap.Error(err))
+			return true
+		}
 
-				err = e.kafkaProducerPool.SendMessage(e.ctx, influxdb_kafka_topic, serializedPp)
-				if err != nil {
-					kafkaProducerLogger.Error("Failed to send processed data to Kafka", zap.Error(err))
-					return
-				}
+		kodelabsMessage, err := e.buildKodelabsMessage(processedDataStruct)
+		if err != nil {
+			msgWorkersLogger.Error("Failed to build Kodelabs payload", zap.Error(err))
+			e.stats.recordError(errorClassKodelabs)
+			continue
+		}
 
-				err = e.kafkaProducerPool.SendMessage(e.ctx, kodelabs_kafka_topic, serializedPp)
-				if err != nil {
-					kafkaProducerLogger.Error("Failed to send processed data to Kafka", zap.Error(err))
-					return
-				}
-			}
+		err = e.sendOutput(ctx, kodelabs_kafka_topic, codec.Record{ID: deserializedData.ID, Timestamp: processedDataStruct.Timestamp, Data: kodelabsMessage})
+		if err != nil {
+			msgProducerLogger.Error("Failed to send Kodelabs data to Kafka", zap.Error(err))
+			return true
 		}
 	}
+
+	return false
 }
@@ -10,4 +10,7 @@ var (
 	FlagVerbose        bool
 	FlagKafkaLogging   bool
 	FlagWorkersLogging bool
+
+	FlagConfigOverrides []string
+	FlagPrintConfig     bool
 )
@@ -0,0 +1,164 @@
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// tagSource returns the DataStruct attribute that a tag mapping refers to
+func tagSource(ds *types.DataStruct, source string) (string, error) {
+	switch strings.ToLower(source) {
+	case "state":
+		return ds.State, nil
+	case "customer", "customer_name":
+		return ds.CustomerName, nil
+	case "customer_id":
+		return ds.CustomerID.String(), nil
+	case "site", "site_name":
+		return ds.SiteName, nil
+	case "site_id":
+		return ds.SiteID.String(), nil
+	case "controller":
+		return ds.Controller, nil
+	case "controller_identifier":
+		return ds.ControllerIdentifier, nil
+	case "device_type":
+		return ds.DeviceType, nil
+	case "device_name":
+		return ds.DeviceName, nil
+	case "device_identifier":
+		return ds.DeviceIdentifier, nil
+	default:
+		return "", fmt.Errorf("unknown tag source: %s", source)
+	}
+}
+
+// EncodePoint encodes a DataStruct as a single line protocol point.
+// Tags maps tag keys to DataStruct attributes, fields renames data keys (keys
+// not present in fields keep their own name). Returns nil when the data
+// contains no values that can be written as fields.
+func EncodePoint(ds *types.DataStruct, measurement string, tags map[string]string, fields map[string]string, precision string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(measurementEscaper.Replace(measurement))
+
+	// Tags must be sorted by key for best write performance
+	tagKeys := make([]string, 0, len(tags))
+	for key := range tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+
+	for _, key := range tagKeys {
+		value, err := tagSource(ds, tags[key])
+		if err != nil {
+			return nil, err
+		}
+
+		// Empty tag values are not allowed in line protocol
+		if value == "" {
+			continue
+		}
+
+		buf.WriteByte(',')
+		buf.WriteString(keyEscaper.Replace(key))
+		buf.WriteByte('=')
+		buf.WriteString(keyEscaper.Replace(value))
+	}
+
+	dataKeys := make([]string, 0, len(ds.Data))
+	for key := range ds.Data {
+		dataKeys = append(dataKeys, key)
+	}
+	sort.Strings(dataKeys)
+
+	fieldCount := 0
+	for _, key := range dataKeys {
+		value, ok := formatFieldValue(ds.Data[key])
+		if !ok {
+			continue
+		}
+
+		name := key
+		if renamed, ok := fields[key]; ok && renamed != "" {
+			name = renamed
+		}
+
+		if fieldCount == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(keyEscaper.Replace(name))
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		fieldCount++
+	}
+
+	if fieldCount == 0 {
+		return nil, nil
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(formatTimestamp(ds.Timestamp, precision), 10))
+
+	return buf.Bytes(), nil
+}
+
+// formatFieldValue formats a data value as a line protocol field value
+func formatFieldValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return formatFieldValue(float64(v))
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint64:
+		return strconv.FormatUint(v, 10) + "u", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`, true
+	default:
+		return "", false
+	}
+}
+
+// formatTimestamp converts a timestamp to the given write precision
+func formatTimestamp(t time.Time, precision string) int64 {
+	switch precision {
+	case "s":
+		return t.Unix()
+	case "ms":
+		return t.UnixMilli()
+	case "us":
+		return t.UnixMicro()
+	default:
+		return t.UnixNano()
+	}
+}
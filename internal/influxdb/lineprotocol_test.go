@@ -0,0 +1,109 @@
+package influxdb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+var testTimestamp = time.Date(2025, 3, 14, 10, 15, 30, 0, time.UTC)
+
+func TestEncodePoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement string
+		tags        map[string]string
+		fields      map[string]string
+		precision   string
+		ds          types.DataStruct
+		want        string
+		err         bool
+	}{
+		{
+			name:        "escaping",
+			measurement: "power meter,v2",
+			tags:        map[string]string{"site name": "site", "customer": "customer"},
+			fields:      map[string]string{"V1": "voltage l1"},
+			precision:   "s",
+			ds: types.DataStruct{
+				CustomerName: "Acme",
+				SiteName:     "Head Office, A=1",
+				Data:         map[string]any{"V1": 231.4, "label": `say "hi" \o/`},
+				Timestamp:    testTimestamp,
+			},
+			want: `power\ meter\,v2,customer=Acme,site\ name=Head\ Office\,\ A\=1 voltage\ l1=231.4,label="say \"hi\" \\o/" 1741947330`,
+		},
+		{
+			name:        "field types",
+			measurement: "power",
+			precision:   "s",
+			ds: types.DataStruct{
+				Data: map[string]any{
+					"float":    12.5,
+					"int":      3,
+					"uint":     uint64(7),
+					"bool":     true,
+					"nan":      math.NaN(),
+					"infinite": math.Inf(1),
+					"nested":   map[string]any{"V1": 231.4},
+				},
+				Timestamp: testTimestamp,
+			},
+			want: `power bool=true,float=12.5,int=3i,uint=7u 1741947330`,
+		},
+		{
+			name:        "empty tag values are left out",
+			measurement: "power",
+			tags:        map[string]string{"device": "device_identifier", "site": "site_name"},
+			precision:   "ms",
+			ds: types.DataStruct{
+				DeviceIdentifier: "CW-1001",
+				Data:             map[string]any{"V1": 231.4},
+				Timestamp:        testTimestamp,
+			},
+			want: `power,device=CW-1001 V1=231.4 1741947330000`,
+		},
+		{
+			name:        "nanosecond precision",
+			measurement: "power",
+			precision:   "ns",
+			ds:          types.DataStruct{Data: map[string]any{"V1": 231.4}, Timestamp: testTimestamp.Add(42)},
+			want:        `power V1=231.4 1741947330000000042`,
+		},
+		{
+			name:        "no field values",
+			measurement: "power",
+			precision:   "s",
+			ds:          types.DataStruct{Data: map[string]any{"nested": map[string]any{}}, Timestamp: testTimestamp},
+		},
+		{
+			name:        "unknown tag source",
+			measurement: "power",
+			tags:        map[string]string{"region": "region"},
+			precision:   "s",
+			ds:          types.DataStruct{Data: map[string]any{"V1": 231.4}, Timestamp: testTimestamp},
+			err:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := EncodePoint(&tt.ds, tt.measurement, tt.tags, tt.fields, tt.precision)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("encoded %q, want an error", line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(line) != tt.want {
+				t.Errorf("encoded %q, want %q", line, tt.want)
+			}
+		})
+	}
+}
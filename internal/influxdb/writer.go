@@ -0,0 +1,270 @@
+package influxdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// ErrQueueFull is returned by Write when the queue is full, which happens
+// while InfluxDB is slow or unavailable. The point is dropped.
+var ErrQueueFull = errors.New("influxdb queue full")
+
+// Writer batches DataStructs and writes them to the InfluxDB v2 write API
+type Writer struct {
+	ctx      context.Context
+	cfg      *app.InfluxDBConfig
+	logger   *zap.Logger
+	client   *http.Client
+	writeURL string
+	lines    chan []byte
+	dropped  atomic.Uint64
+	wg       sync.WaitGroup
+}
+
+// NewWriter creates a new Writer and starts its background flusher
+func NewWriter(ctx context.Context, cfg *app.InfluxDBConfig, logger *zap.Logger) (*Writer, error) {
+	writeURL, err := buildWriteURL(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid InfluxDB batch size: %d", cfg.BatchSize)
+	}
+
+	if cfg.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid InfluxDB flush interval: %d", cfg.FlushInterval)
+	}
+
+	w := &Writer{
+		ctx:      ctx,
+		cfg:      cfg,
+		logger:   logger,
+		client:   &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		writeURL: writeURL,
+		lines:    make(chan []byte, cfg.BatchSize*4),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w, nil
+}
+
+// buildWriteURL builds the /api/v2/write URL from the config
+func buildWriteURL(cfg *app.InfluxDBConfig) (string, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid InfluxDB URL: %w", err)
+	}
+
+	switch cfg.Precision {
+	case "s", "ms", "us", "ns":
+	default:
+		return "", fmt.Errorf("invalid InfluxDB precision: %s", cfg.Precision)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+
+	query := url.Values{}
+	query.Set("org", cfg.Org)
+	query.Set("bucket", cfg.Bucket)
+	query.Set("precision", cfg.Precision)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Write encodes the DataStruct as a line protocol point and queues it for the
+// next batch. It never blocks, so an InfluxDB outage cannot hold up consumption.
+func (w *Writer) Write(ds *types.DataStruct) error {
+	line, err := EncodePoint(ds, w.cfg.Measurement, w.cfg.Tags, w.cfg.Fields, w.cfg.Precision)
+	if err != nil {
+		return fmt.Errorf("failed to encode point: %w", err)
+	}
+
+	// Nothing to write
+	if line == nil {
+		return nil
+	}
+
+	if w.ctx.Err() != nil {
+		return fmt.Errorf("influxdb writer stopped")
+	}
+
+	select {
+	case w.lines <- line:
+		return nil
+	default:
+		w.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// run collects queued lines and flushes them by batch size or flush interval
+func (w *Writer) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, w.cfg.BatchSize)
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			// Drain whatever is still queued and flush it one last time
+		drain:
+			for {
+				select {
+				case line := <-w.lines:
+					batch = append(batch, line)
+				default:
+					break drain
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.cfg.Timeout)*time.Second)
+			w.flush(ctx, batch)
+			cancel()
+			return
+		case line := <-w.lines:
+			batch = append(batch, line)
+			if len(batch) >= w.cfg.BatchSize {
+				w.flush(w.ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			// Dropped points are logged once per interval instead of per point
+			if dropped := w.dropped.Swap(0); dropped > 0 {
+				w.logger.Error("InfluxDB queue full, dropped points", zap.Uint64("points", dropped))
+			}
+			if len(batch) > 0 {
+				w.flush(w.ctx, batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush writes a batch, retrying on network errors, 429 and 5xx responses
+func (w *Writer) flush(ctx context.Context, batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := w.encodeBody(batch)
+	if err != nil {
+		w.logger.Error("Failed to encode InfluxDB batch", zap.Error(err), zap.Int("points", len(batch)))
+		return
+	}
+
+	retryInterval := time.Duration(w.cfg.RetryInterval) * time.Second
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := w.post(ctx, body)
+		if err == nil {
+			w.logger.Debug("Wrote batch to InfluxDB", zap.Int("points", len(batch)))
+			return
+		}
+
+		if retryAfter < 0 || attempt >= w.cfg.MaxRetries {
+			w.logger.Error("Failed to write batch to InfluxDB", zap.Error(err), zap.Int("points", len(batch)), zap.Int("attempts", attempt+1))
+			return
+		}
+
+		wait := retryInterval << attempt
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+
+		w.logger.Warn("Failed to write batch to InfluxDB, retrying...", zap.Error(err), zap.Duration("retry_after", wait))
+
+		select {
+		case <-ctx.Done():
+			w.logger.Error("Dropped InfluxDB batch during shutdown", zap.Int("points", len(batch)))
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// encodeBody joins the batch into a newline separated body, gzipped if configured
+func (w *Writer) encodeBody(batch [][]byte) ([]byte, error) {
+	joined := bytes.Join(batch, []byte("\n"))
+
+	if !w.cfg.Gzip {
+		return joined, nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(joined); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// post sends a single write request. A negative retryAfter means the error is
+// permanent and the batch must not be retried.
+func (w *Writer) post(ctx context.Context, body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return -1, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	}
+	if w.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, err
+		}
+		return 0, err
+	}
+
+	return -1, err
+}
+
+// Close waits for the final flush to complete. The writer's context must be
+// cancelled first.
+func (w *Writer) Close() {
+	w.wg.Wait()
+}
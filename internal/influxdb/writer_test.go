@@ -0,0 +1,337 @@
+package influxdb
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// request is a write request received by the test server
+type request struct {
+	query  url.Values
+	header http.Header
+	lines  []string
+	at     time.Time
+}
+
+// newTestServer starts an InfluxDB write API that records every request and
+// answers the n-th one (counted from 1) with respond, or 204 if respond is nil
+func newTestServer(t *testing.T, respond func(n int, w http.ResponseWriter)) (*httptest.Server, chan request) {
+	t.Helper()
+
+	requests := make(chan request, 100)
+	var count atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		requests <- request{query: r.URL.Query(), header: r.Header, lines: strings.Split(string(data), "\n"), at: time.Now()}
+
+		if respond != nil {
+			respond(int(count.Add(1)), w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+// newTestWriter creates a writer for the test server. The writer is stopped
+// when the test ends.
+func newTestWriter(t *testing.T, serverURL string, configure func(cfg *app.InfluxDBConfig)) (*Writer, context.CancelFunc) {
+	t.Helper()
+
+	cfg := &app.InfluxDBConfig{
+		URL:           serverURL,
+		Org:           "acme",
+		Bucket:        "telemetry",
+		Token:         "secret",
+		Precision:     "s",
+		Measurement:   "power",
+		Tags:          map[string]string{"device": "device_identifier"},
+		BatchSize:     2,
+		FlushInterval: 60,
+		MaxRetries:    3,
+		RetryInterval: 60,
+		Timeout:       5,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w, err := NewWriter(ctx, cfg, zap.NewNop())
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		w.Close()
+	})
+
+	return w, cancel
+}
+
+// writePoints writes a point per value of V1 for device CW-1001
+func writePoints(t *testing.T, w *Writer, values ...int) {
+	t.Helper()
+
+	for _, value := range values {
+		ds := &types.DataStruct{DeviceIdentifier: "CW-1001", Data: map[string]any{"V1": value}, Timestamp: testTimestamp}
+		if err := w.Write(ds); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pointLines returns the line protocol of the points written by writePoints
+func pointLines(values ...int) []string {
+	var lines []string
+	for _, value := range values {
+		lines = append(lines, fmt.Sprintf("power,device=CW-1001 V1=%di 1741947330", value))
+	}
+
+	return lines
+}
+
+// nextRequest waits for the next request received by the test server
+func nextRequest(t *testing.T, requests chan request, timeout time.Duration) request {
+	t.Helper()
+
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(timeout):
+		t.Fatalf("no write request within %s", timeout)
+		return request{}
+	}
+}
+
+func assertLines(t *testing.T, r request, want []string) {
+	t.Helper()
+
+	if fmt.Sprint(r.lines) != fmt.Sprint(want) {
+		t.Errorf("request wrote %q, want %q", r.lines, want)
+	}
+}
+
+func TestFlushOnBatchSize(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("gzip %t", compress), func(t *testing.T) {
+			server, requests := newTestServer(t, nil)
+			w, _ := newTestWriter(t, server.URL, func(cfg *app.InfluxDBConfig) { cfg.Gzip = compress })
+
+			// The flush interval is a minute, so only a full batch is written
+			writePoints(t, w, 1, 2, 3)
+
+			r := nextRequest(t, requests, 5*time.Second)
+			assertLines(t, r, pointLines(1, 2))
+
+			if got := r.query.Encode(); got != "bucket=telemetry&org=acme&precision=s" {
+				t.Errorf("request query is %q, want the bucket, org and precision", got)
+			}
+			if got := r.header.Get("Authorization"); got != "Token secret" {
+				t.Errorf("Authorization header is %q, want Token secret", got)
+			}
+			if compress != (r.header.Get("Content-Encoding") == "gzip") {
+				t.Errorf("Content-Encoding header is %q with gzip %t", r.header.Get("Content-Encoding"), compress)
+			}
+
+			select {
+			case r := <-requests:
+				t.Fatalf("wrote %q before the batch was full", r.lines)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestFlushOnInterval(t *testing.T) {
+	server, requests := newTestServer(t, nil)
+	w, _ := newTestWriter(t, server.URL, func(cfg *app.InfluxDBConfig) {
+		cfg.BatchSize = 100
+		cfg.FlushInterval = 1
+	})
+
+	start := time.Now()
+	writePoints(t, w, 1)
+
+	r := nextRequest(t, requests, 5*time.Second)
+	assertLines(t, r, pointLines(1))
+
+	if elapsed := r.at.Sub(start); elapsed < 900*time.Millisecond {
+		t.Errorf("wrote a partial batch after %s, want the flush interval of 1s", elapsed)
+	}
+}
+
+func TestFlushOnShutdown(t *testing.T) {
+	server, requests := newTestServer(t, nil)
+	w, cancel := newTestWriter(t, server.URL, func(cfg *app.InfluxDBConfig) { cfg.BatchSize = 100 })
+
+	writePoints(t, w, 1, 2)
+	cancel()
+	w.Close()
+
+	assertLines(t, nextRequest(t, requests, time.Second), pointLines(1, 2))
+
+	ds := &types.DataStruct{DeviceIdentifier: "CW-1001", Data: map[string]any{"V1": 3}, Timestamp: testTimestamp}
+	if err := w.Write(ds); err == nil {
+		t.Error("stopped writer accepted a point")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		retryAfter    string
+		maxRetries    int
+		retryInterval int
+		attempts      int
+		minWait       time.Duration
+	}{
+		{
+			name:          "429 honours Retry-After",
+			statuses:      []int{http.StatusTooManyRequests},
+			retryAfter:    "1",
+			maxRetries:    3,
+			retryInterval: 60,
+			attempts:      2,
+			minWait:       time.Second,
+		},
+		{
+			name:          "5xx waits the retry interval",
+			statuses:      []int{http.StatusServiceUnavailable},
+			maxRetries:    3,
+			retryInterval: 1,
+			attempts:      2,
+			minWait:       time.Second,
+		},
+		{
+			name:          "retries are limited",
+			statuses:      []int{http.StatusInternalServerError, http.StatusInternalServerError},
+			retryAfter:    "1",
+			maxRetries:    1,
+			retryInterval: 60,
+			attempts:      2,
+			minWait:       time.Second,
+		},
+		{
+			name:          "4xx is not retried",
+			statuses:      []int{http.StatusBadRequest},
+			maxRetries:    3,
+			retryInterval: 60,
+			attempts:      1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, func(n int, w http.ResponseWriter) {
+				if n > len(tt.statuses) {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				http.Error(w, "unavailable", tt.statuses[n-1])
+			})
+			w, _ := newTestWriter(t, server.URL, func(cfg *app.InfluxDBConfig) {
+				cfg.MaxRetries = tt.maxRetries
+				cfg.RetryInterval = tt.retryInterval
+			})
+
+			// The second batch waits for the first one to succeed or be dropped
+			writePoints(t, w, 1, 2, 3, 4)
+
+			var previous time.Time
+			for attempt := 1; attempt <= tt.attempts; attempt++ {
+				r := nextRequest(t, requests, 5*time.Second)
+				assertLines(t, r, pointLines(1, 2))
+
+				if attempt > 1 && r.at.Sub(previous) < tt.minWait-100*time.Millisecond {
+					t.Errorf("attempt %d after %s, want at least %s", attempt, r.at.Sub(previous), tt.minWait)
+				}
+				previous = r.at
+			}
+
+			assertLines(t, nextRequest(t, requests, 5*time.Second), pointLines(3, 4))
+		})
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	server, requests := newTestServer(t, func(n int, w http.ResponseWriter) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+	w, cancel := newTestWriter(t, server.URL, func(cfg *app.InfluxDBConfig) { cfg.BatchSize = 1 })
+
+	// The first point is flushed and InfluxDB hangs on it
+	writePoints(t, w, 1)
+	nextRequest(t, requests, 5*time.Second)
+
+	// The queue holds four batches of one point, the next point is dropped
+	writePoints(t, w, 2, 3, 4, 5)
+
+	ds := &types.DataStruct{DeviceIdentifier: "CW-1001", Data: map[string]any{"V1": 6}, Timestamp: testTimestamp}
+	if err := w.Write(ds); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got error %v with a full queue, want %v", err, ErrQueueFull)
+	}
+	if dropped := w.dropped.Load(); dropped != 1 {
+		t.Errorf("counted %d dropped points, want 1", dropped)
+	}
+
+	// The queued points are written once InfluxDB recovers, the dropped one is not
+	close(release)
+
+	var written []string
+	for i := 0; i < 4; i++ {
+		written = append(written, nextRequest(t, requests, 5*time.Second).lines...)
+	}
+	if want := pointLines(2, 3, 4, 5); fmt.Sprint(written) != fmt.Sprint(want) {
+		t.Errorf("wrote %q after InfluxDB recovered, want %q", written, want)
+	}
+
+	cancel()
+	w.Close()
+
+	if len(requests) > 0 {
+		t.Errorf("wrote %q after the queue drained", (<-requests).lines)
+	}
+}
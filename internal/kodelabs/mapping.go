@@ -0,0 +1,121 @@
+package kodelabs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+)
+
+const (
+	// DefaultMappingName is the mapping file used for customers without their own mapping
+	DefaultMappingName = "default"
+
+	// DefaultIdentifier is the identifier template used when a mapping does not define one
+	DefaultIdentifier = "{device_identifier}"
+)
+
+// Mapping describes how our device data maps onto Kodelabs points for one customer
+type Mapping struct {
+	// Identifier is a template for the Kodelabs device identifier, e.g. "{site}.{device_name}"
+	Identifier string `yaml:"identifier"`
+
+	// Points maps our data keys to Kodelabs points
+	Points map[string]PointMapping `yaml:"points"`
+
+	// DropUnmapped drops data keys that have no entry in Points instead of
+	// passing them through under their own name
+	DropUnmapped bool `yaml:"drop_unmapped"`
+}
+
+// PointMapping maps a single data key to a Kodelabs point
+type PointMapping struct {
+	Name   string   `yaml:"name"`
+	Unit   string   `yaml:"unit"`
+	Scale  *float64 `yaml:"scale,omitempty"`
+	Offset float64  `yaml:"offset"`
+}
+
+// defaultMapping is used when neither a customer nor a default mapping file exists
+var defaultMapping = &Mapping{
+	Identifier: DefaultIdentifier,
+	Points:     map[string]PointMapping{},
+}
+
+type cachedMapping struct {
+	mapping *Mapping
+	modTime time.Time
+}
+
+// MappingStore loads per-customer mapping files from a directory and reloads
+// them when they change on disk
+type MappingStore struct {
+	dir   string
+	mu    sync.Mutex
+	cache map[string]cachedMapping
+}
+
+// NewMappingStore creates a new MappingStore for the given directory
+func NewMappingStore(dir string) *MappingStore {
+	return &MappingStore{
+		dir:   dir,
+		cache: make(map[string]cachedMapping),
+	}
+}
+
+// mappingFilePath returns the mapping file path for a customer
+func (s *MappingStore) mappingFilePath(name string) string {
+	return filepath.Join(s.dir, strings.ToLower(name)+".yaml")
+}
+
+// Get returns the mapping for a customer, falling back to the default mapping
+func (s *MappingStore) Get(customer string) (*Mapping, error) {
+	mapping, err := s.load(customer)
+	if err != nil || mapping != nil {
+		return mapping, err
+	}
+
+	mapping, err = s.load(DefaultMappingName)
+	if err != nil || mapping != nil {
+		return mapping, err
+	}
+
+	return defaultMapping, nil
+}
+
+// load returns the mapping stored under name, or nil if no such file exists
+func (s *MappingStore) load(name string) (*Mapping, error) {
+	filePath := s.mappingFilePath(name)
+
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat mapping file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, ok := s.cache[filePath]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.mapping, nil
+	}
+
+	var mapping Mapping
+	if err := coreutils.LoadYAMLFile(filePath, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to load mapping file %s: %w", filePath, err)
+	}
+
+	if mapping.Identifier == "" {
+		mapping.Identifier = DefaultIdentifier
+	}
+
+	s.cache[filePath] = cachedMapping{mapping: &mapping, modTime: info.ModTime()}
+
+	return &mapping, nil
+}
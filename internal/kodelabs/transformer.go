@@ -0,0 +1,120 @@
+package kodelabs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+// Message is the Kodelabs output schema
+type Message struct {
+	Identifier string    `json:"identifier"`
+	Customer   string    `json:"customer"`
+	Site       string    `json:"site"`
+	DeviceType string    `json:"device_type"`
+	Timestamp  time.Time `json:"timestamp"`
+	Points     []Point   `json:"points"`
+}
+
+// Point is a single Kodelabs point value
+type Point struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+// Transformer maps DataStructs onto the Kodelabs output schema
+type Transformer struct {
+	mappings *MappingStore
+}
+
+// NewTransformer creates a new Transformer reading mappings from mappingDir
+func NewTransformer(mappingDir string) *Transformer {
+	return &Transformer{
+		mappings: NewMappingStore(mappingDir),
+	}
+}
+
+// Transform converts a DataStruct into a Kodelabs message using the customer's mapping
+func (t *Transformer) Transform(ds *types.DataStruct) (*Message, error) {
+	mapping, err := t.mappings.Get(ds.CustomerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kodelabs mapping for %s: %w", ds.CustomerName, err)
+	}
+
+	identifier := expandIdentifier(mapping.Identifier, ds)
+	if identifier == "" {
+		return nil, fmt.Errorf("empty Kodelabs identifier for device: %s", ds.DeviceIdentifier)
+	}
+
+	keys := make([]string, 0, len(ds.Data))
+	for key := range ds.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	points := make([]Point, 0, len(keys))
+	for _, key := range keys {
+		pointMapping, ok := mapping.Points[key]
+		if !ok {
+			if mapping.DropUnmapped {
+				continue
+			}
+			pointMapping = PointMapping{Name: key}
+		}
+
+		name := pointMapping.Name
+		if name == "" {
+			name = key
+		}
+
+		points = append(points, Point{
+			Name:  name,
+			Value: convertValue(ds.Data[key], pointMapping),
+			Unit:  pointMapping.Unit,
+		})
+	}
+
+	return &Message{
+		Identifier: identifier,
+		Customer:   ds.CustomerName,
+		Site:       ds.SiteName,
+		DeviceType: ds.DeviceType,
+		Timestamp:  ds.Timestamp,
+		Points:     points,
+	}, nil
+}
+
+// expandIdentifier replaces the identifier template placeholders with device attributes
+func expandIdentifier(template string, ds *types.DataStruct) string {
+	replacer := strings.NewReplacer(
+		"{customer}", ds.CustomerName,
+		"{customer_id}", ds.CustomerID.String(),
+		"{site}", ds.SiteName,
+		"{site_id}", ds.SiteID.String(),
+		"{controller}", ds.Controller,
+		"{controller_identifier}", ds.ControllerIdentifier,
+		"{device_type}", ds.DeviceType,
+		"{device_name}", ds.DeviceName,
+		"{device_identifier}", ds.DeviceIdentifier,
+	)
+
+	return replacer.Replace(template)
+}
+
+// convertValue applies the unit conversion of a point mapping to numeric values
+func convertValue(value any, pointMapping PointMapping) any {
+	number, ok := value.(float64)
+	if !ok {
+		return value
+	}
+
+	if pointMapping.Scale != nil {
+		number *= *pointMapping.Scale
+	}
+
+	return number + pointMapping.Offset
+}
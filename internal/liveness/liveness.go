@@ -0,0 +1,273 @@
+package liveness
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+// Event types
+const (
+	EventOnline  = "online"
+	EventOffline = "offline"
+)
+
+// Event kinds
+const (
+	KindDevice     = "device"
+	KindController = "controller"
+)
+
+// Event is emitted when a device or controller changes between online and offline
+type Event struct {
+	Type         string    `json:"type"`
+	Kind         string    `json:"kind"`
+	Identifier   string    `json:"identifier"`
+	CustomerName string    `json:"customer_name"`
+	SiteName     string    `json:"site_name"`
+	Controller   string    `json:"controller,omitempty"`
+	DeviceType   string    `json:"device_type,omitempty"`
+	DeviceName   string    `json:"device_name,omitempty"`
+	LastSeen     time.Time `json:"last_seen"`
+	Threshold    string    `json:"threshold"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// entry holds the liveness state of a single device or controller. The
+// silence is counted from silenceSince, which is lastSeen unless the tracker
+// was re-armed after it.
+type entry struct {
+	event        Event
+	lastSeen     time.Time
+	silenceSince time.Time
+	threshold    time.Duration
+	offline      bool
+}
+
+// Tracker records when devices and controllers were last seen and detects
+// when they go silent for longer than their threshold
+type Tracker struct {
+	mu                  sync.Mutex
+	defaultInterval     time.Duration
+	deviceTypeIntervals map[string]time.Duration
+	missedIntervals     int
+	devices             map[string]*entry
+	controllers         map[string]*entry
+}
+
+// NewTracker creates a new Tracker. A device is considered offline once it
+// missed missedIntervals of its expected reporting interval.
+func NewTracker(defaultInterval time.Duration, deviceTypeIntervals map[string]time.Duration, missedIntervals int) *Tracker {
+	intervals := make(map[string]time.Duration, len(deviceTypeIntervals))
+	for deviceType, interval := range deviceTypeIntervals {
+		intervals[strings.ToLower(deviceType)] = interval
+	}
+
+	if missedIntervals < 1 {
+		missedIntervals = 1
+	}
+
+	return &Tracker{
+		defaultInterval:     defaultInterval,
+		deviceTypeIntervals: intervals,
+		missedIntervals:     missedIntervals,
+		devices:             make(map[string]*entry),
+		controllers:         make(map[string]*entry),
+	}
+}
+
+// threshold returns how long a device of the given type may stay silent
+func (t *Tracker) threshold(deviceType string) time.Duration {
+	interval, ok := t.deviceTypeIntervals[strings.ToLower(deviceType)]
+	if !ok {
+		interval = t.defaultInterval
+	}
+
+	return interval * time.Duration(t.missedIntervals)
+}
+
+// Seen records a device report and returns online events for the device and
+// its controller if they were offline
+func (t *Tracker) Seen(device types.Device, at time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	threshold := t.threshold(device.DeviceType)
+
+	var events []Event
+
+	deviceEvent := Event{
+		Kind:         KindDevice,
+		Identifier:   device.DeviceIdentifier,
+		CustomerName: device.CustomerName,
+		SiteName:     device.SiteName,
+		Controller:   device.Controller,
+		DeviceType:   device.DeviceType,
+		DeviceName:   device.DeviceName,
+	}
+	if event, ok := seen(t.devices, device.DeviceIdentifier, deviceEvent, threshold, at); ok {
+		events = append(events, event)
+	}
+
+	if device.ControllerIdentifier != "" {
+		controllerEvent := Event{
+			Kind:         KindController,
+			Identifier:   device.ControllerIdentifier,
+			CustomerName: device.CustomerName,
+			SiteName:     device.SiteName,
+			Controller:   device.Controller,
+		}
+
+		// A controller stays online as long as its slowest reporting device
+		if existing, ok := t.controllers[device.ControllerIdentifier]; ok && existing.threshold > threshold {
+			threshold = existing.threshold
+		}
+
+		if event, ok := seen(t.controllers, device.ControllerIdentifier, controllerEvent, threshold, at); ok {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// seen updates a single entry and returns an online event if it was offline
+func seen(entries map[string]*entry, identifier string, event Event, threshold time.Duration, at time.Time) (Event, bool) {
+	e, ok := entries[identifier]
+	if !ok {
+		entries[identifier] = &entry{event: event, lastSeen: at, silenceSince: at, threshold: threshold}
+		return Event{}, false
+	}
+
+	wasOffline := e.offline
+	e.event = event
+	e.lastSeen = at
+	e.silenceSince = at
+	e.threshold = threshold
+	e.offline = false
+
+	if !wasOffline {
+		return Event{}, false
+	}
+
+	return e.newEvent(EventOnline, at), true
+}
+
+// Check returns offline events for every device and controller that has been
+// silent for longer than its threshold
+func (t *Tracker) Check(now time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []Event
+	for _, entries := range []map[string]*entry{t.devices, t.controllers} {
+		for _, e := range entries {
+			if e.offline || now.Sub(e.silenceSince) <= e.threshold {
+				continue
+			}
+
+			e.offline = true
+			events = append(events, e.newEvent(EventOffline, now))
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Identifier < events[j].Identifier
+	})
+
+	return events
+}
+
+// Snapshot is the persisted state of a Tracker
+type Snapshot struct {
+	Devices     map[string]SnapshotEntry `json:"devices"`
+	Controllers map[string]SnapshotEntry `json:"controllers"`
+}
+
+// SnapshotEntry is the state of a single device or controller. Event holds the
+// details offline events are sent with.
+type SnapshotEntry struct {
+	LastSeen  time.Time `json:"last_seen"`
+	Offline   bool      `json:"offline"`
+	Threshold string    `json:"threshold"`
+	Event     Event     `json:"event"`
+}
+
+// Snapshot returns the state of all devices and controllers
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := func(entries map[string]*entry) map[string]SnapshotEntry {
+		result := make(map[string]SnapshotEntry, len(entries))
+		for identifier, e := range entries {
+			result[identifier] = SnapshotEntry{
+				LastSeen:  e.lastSeen,
+				Offline:   e.offline,
+				Threshold: e.threshold.String(),
+				Event:     e.event,
+			}
+		}
+		return result
+	}
+
+	return Snapshot{
+		Devices:     snapshot(t.devices),
+		Controllers: snapshot(t.controllers),
+	}
+}
+
+// Restore loads a snapshot taken before a restart, so devices that do not
+// report again still go offline. Entries seen since the start are kept.
+func (t *Tracker) Restore(snapshot Snapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	restore := func(entries map[string]*entry, snapshot map[string]SnapshotEntry) {
+		for identifier, s := range snapshot {
+			if _, ok := entries[identifier]; ok || s.LastSeen.IsZero() {
+				continue
+			}
+
+			threshold, err := time.ParseDuration(s.Threshold)
+			if err != nil || threshold <= 0 {
+				threshold = t.threshold(s.Event.DeviceType)
+			}
+
+			entries[identifier] = &entry{event: s.Event, lastSeen: s.LastSeen, silenceSince: s.LastSeen, threshold: threshold, offline: s.Offline}
+		}
+	}
+
+	restore(t.devices, snapshot.Devices)
+	restore(t.controllers, snapshot.Controllers)
+}
+
+// Rearm restarts the silence of every online device and controller at now. It
+// is used when consumption resumes, as devices were not heard while paused.
+// The last seen times are kept, so events still report the last real report.
+func (t *Tracker) Rearm(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, entries := range []map[string]*entry{t.devices, t.controllers} {
+		for _, e := range entries {
+			if !e.offline && e.silenceSince.Before(now) {
+				e.silenceSince = now
+			}
+		}
+	}
+}
+
+// newEvent creates an event of the given type from the entry
+func (e *entry) newEvent(eventType string, at time.Time) Event {
+	event := e.event
+	event.Type = eventType
+	event.LastSeen = e.lastSeen
+	event.Threshold = e.threshold.String()
+	event.Timestamp = at
+
+	return event
+}
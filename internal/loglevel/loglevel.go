@@ -0,0 +1,153 @@
+package loglevel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/johandrevandeventer/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The base logger is created at debug level and entries are filtered here, so
+// that levels can be changed while the application is running
+var (
+	level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	mu        sync.RWMutex
+	overrides = make(map[string]zapcore.Level)
+)
+
+// Logger returns the named logger with a level that can be changed at runtime
+func Logger(name string) *zap.Logger {
+	return Wrap(logging.GetLogger(name), name)
+}
+
+// Wrap applies the runtime level of the named logger to an existing logger
+func Wrap(logger *zap.Logger, name string) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, name: name}
+	}))
+}
+
+// Level returns the global log level
+func Level() zapcore.Level {
+	return level.Level()
+}
+
+// SetLevel sets the global log level
+func SetLevel(l zapcore.Level) {
+	level.SetLevel(l)
+}
+
+// SetLoggerLevel overrides the level of a named logger and the loggers below
+// it, e.g. "kafka" also applies to "kafka.producer"
+func SetLoggerLevel(name string, l zapcore.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	overrides[name] = l
+}
+
+// ResetLoggerLevel removes the level override of a named logger
+func ResetLoggerLevel(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(overrides, name)
+}
+
+// Levels returns the global level and the level overrides of named loggers
+func Levels() (global string, loggers map[string]string) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	loggers = make(map[string]string, len(overrides))
+	for name, l := range overrides {
+		loggers[name] = l.String()
+	}
+
+	return level.Level().String(), loggers
+}
+
+// Set parses a level setting and applies it. The setting is either a level,
+// e.g. "debug", which sets the global level, or "<logger>=<level>", e.g.
+// "workers=debug", which overrides a named logger. "<logger>=reset" removes
+// the override.
+func Set(setting string) error {
+	setting = strings.TrimSpace(setting)
+
+	name, value, named := strings.Cut(setting, "=")
+	if !named {
+		l, err := zapcore.ParseLevel(setting)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q", setting)
+		}
+		SetLevel(l)
+		return nil
+	}
+
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if name == "" {
+		return fmt.Errorf("invalid log level setting %q: empty logger name", setting)
+	}
+
+	if value == "reset" {
+		ResetLoggerLevel(name)
+		return nil
+	}
+
+	l, err := zapcore.ParseLevel(value)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", value)
+	}
+	SetLoggerLevel(name, l)
+
+	return nil
+}
+
+// enabled reports whether a named logger logs at the given level. The most
+// specific override wins, otherwise the global level applies.
+func enabled(name string, l zapcore.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	longest := -1
+	var overrideLevel zapcore.Level
+	for overrideName, ol := range overrides {
+		if (name == overrideName || strings.HasPrefix(name, overrideName+".")) && len(overrideName) > longest {
+			longest = len(overrideName)
+			overrideLevel = ol
+		}
+	}
+
+	if longest >= 0 {
+		return l >= overrideLevel
+	}
+
+	return level.Enabled(l)
+}
+
+// levelCore filters the entries of the wrapped core by the runtime level
+type levelCore struct {
+	zapcore.Core
+	name string
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return enabled(c.name, l) && c.Core.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), name: c.name}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !enabled(c.name, entry.Level) {
+		return checked
+	}
+
+	return c.Core.Check(entry, checked)
+}
@@ -0,0 +1,487 @@
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExt     = ".seg"
+	cursorFileName = "cursor"
+
+	// recordHeaderSize is the size of the length and checksum in front of
+	// every record
+	recordHeaderSize = 8
+)
+
+// Record is a spooled message
+type Record struct {
+	Time    time.Time      `json:"time"`
+	Topic   string         `json:"topic"`
+	Value   []byte         `json:"value"`
+	Headers []kafka.Header `json:"headers,omitempty"`
+}
+
+// Stats are the counters of a spool
+type Stats struct {
+	Records        int    `json:"records"`
+	Bytes          int64  `json:"bytes"`
+	Segments       int    `json:"segments"`
+	Spooled        uint64 `json:"spooled"`
+	Replayed       uint64 `json:"replayed"`
+	DroppedSize    uint64 `json:"dropped_size"`
+	DroppedAge     uint64 `json:"dropped_age"`
+	DroppedCorrupt uint64 `json:"dropped_corrupt"`
+}
+
+// segment is a spool file. Records are appended to the newest segment and
+// read from the oldest one.
+type segment struct {
+	seq     uint64
+	size    int64
+	records int
+}
+
+// Spool is a bounded write-ahead log of messages that could not be sent. It
+// is stored as numbered segment files in a directory, and a cursor file holds
+// the read position in the oldest segment so replayed records are not sent
+// again after a restart.
+//
+// Every record is framed by its length and a CRC-32 of its data. A torn
+// record at the end of a segment, left by a crash, ends the segment.
+type Spool struct {
+	mu           sync.Mutex
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	maxAge       time.Duration
+	logger       *zap.Logger
+	segments     []*segment
+	writer       *os.File
+	readOffset   int64
+	stats        Stats
+}
+
+// Open opens the spool in the configured directory, creating it if needed
+func Open(cfg *app.SpoolConfig, logger *zap.Logger) (*Spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		dir:          cfg.Dir,
+		maxBytes:     int64(cfg.MaxSizeMB) << 20,
+		segmentBytes: int64(cfg.SegmentSizeMB) << 20,
+		maxAge:       time.Duration(cfg.MaxAge) * time.Hour,
+		logger:       logger,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the existing segments and the cursor
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, &segment{seq: seq})
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if len(s.segments) > 0 {
+		s.readOffset = s.readCursor(s.segments[0].seq)
+	}
+
+	for n, seg := range s.segments {
+		var offset int64
+		if n == 0 {
+			offset = s.readOffset
+		}
+
+		if err := s.scan(seg, offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scan counts the records of a segment from an offset. A torn record at the
+// end of the segment is truncated.
+func (s *Spool) scan(seg *segment, offset int64) error {
+	file, err := os.OpenFile(s.segmentPath(seg.seq), os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	if offset > info.Size() {
+		offset = 0
+	}
+
+	for {
+		_, next, err := readRecord(file, offset, info.Size())
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			s.logger.Warn("Truncating damaged spool segment", zap.Uint64("segment", seg.seq), zap.Int64("offset", offset), zap.Error(err))
+			if err := file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate spool segment: %w", err)
+			}
+			break
+		}
+
+		seg.records++
+		offset = next
+	}
+
+	seg.size = offset
+	s.stats.Records += seg.records
+	s.stats.Bytes += seg.size
+
+	return nil
+}
+
+// Append adds a record to the end of the spool. If the spool is larger than
+// its maximum size afterwards, the oldest segments are dropped.
+func (s *Spool) Append(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to serialize spool record: %w", err)
+	}
+
+	buf := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[recordHeaderSize:], data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer == nil || s.current().size+int64(len(buf)) > s.segmentBytes && s.current().size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.writer.Write(buf); err != nil {
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+
+	seg := s.current()
+	seg.size += int64(len(buf))
+	seg.records++
+	s.stats.Records++
+	s.stats.Bytes += int64(len(buf))
+	s.stats.Spooled++
+
+	s.enforceSize()
+
+	return nil
+}
+
+// current returns the segment records are appended to
+func (s *Spool) current() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate closes the current segment and starts a new one
+func (s *Spool) rotate() error {
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			s.logger.Error("Failed to close spool segment", zap.Error(err))
+		}
+		s.writer = nil
+	}
+
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.current().seq + 1
+	}
+
+	writer, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	s.writer = writer
+	s.segments = append(s.segments, &segment{seq: seq})
+
+	return nil
+}
+
+// enforceSize drops the oldest segments while the spool is too large. The
+// segment being written is never dropped.
+func (s *Spool) enforceSize() {
+	for s.maxBytes > 0 && s.stats.Bytes > s.maxBytes && len(s.segments) > 1 {
+		seg := s.segments[0]
+		s.logger.Warn("Spool is full, dropping oldest messages", zap.Uint64("segment", seg.seq), zap.Int("records", seg.records))
+		s.stats.DroppedSize += uint64(seg.records)
+		s.removeOldest()
+	}
+}
+
+// Expire drops the segments that were last written before the maximum age
+func (s *Spool) Expire(now time.Time) {
+	if s.maxAge <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 1 {
+		seg := s.segments[0]
+		info, err := os.Stat(s.segmentPath(seg.seq))
+		if err != nil || now.Sub(info.ModTime()) <= s.maxAge {
+			return
+		}
+
+		s.logger.Warn("Dropping expired spooled messages", zap.Uint64("segment", seg.seq), zap.Int("records", seg.records))
+		s.stats.DroppedAge += uint64(seg.records)
+		s.removeOldest()
+	}
+}
+
+// removeOldest deletes the oldest segment
+func (s *Spool) removeOldest() {
+	seg := s.segments[0]
+
+	if err := os.Remove(s.segmentPath(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Error("Failed to remove spool segment", zap.Uint64("segment", seg.seq), zap.Error(err))
+	}
+
+	s.stats.Records -= seg.records
+	s.stats.Bytes -= seg.size
+	s.segments = s.segments[1:]
+	s.readOffset = 0
+}
+
+// Replay passes up to max records to fn in the order they were appended. A
+// record is removed once fn returns nil; if fn fails, replay stops and the
+// record is replayed again on the next call. Records older than the maximum
+// age are dropped instead of replayed.
+func (s *Spool) Replay(max int, fn func(Record) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer s.writeCursor()
+
+	replayed := 0
+	for replayed < max && s.stats.Records > 0 {
+		seg := s.segments[0]
+
+		if s.readOffset >= seg.size {
+			if len(s.segments) == 1 {
+				break
+			}
+			s.removeOldest()
+			continue
+		}
+
+		record, next, err := s.readAt(seg, s.readOffset)
+		if err != nil {
+			s.logger.Error("Skipping damaged spool segment", zap.Uint64("segment", seg.seq), zap.Int64("offset", s.readOffset), zap.Error(err))
+			s.stats.DroppedCorrupt += uint64(seg.records)
+			s.stats.Records -= seg.records
+			seg.records = 0
+			s.readOffset = seg.size
+			continue
+		}
+
+		if s.maxAge > 0 && time.Since(record.Time) > s.maxAge {
+			s.stats.DroppedAge++
+		} else {
+			if err := fn(record); err != nil {
+				return replayed, err
+			}
+			s.stats.Replayed++
+			replayed++
+		}
+
+		seg.records--
+		s.stats.Records--
+		s.readOffset = next
+	}
+
+	if s.stats.Records == 0 {
+		s.reset()
+	}
+
+	return replayed, nil
+}
+
+// reset removes the segments of an empty spool
+func (s *Spool) reset() {
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			s.logger.Error("Failed to close spool segment", zap.Error(err))
+		}
+		s.writer = nil
+	}
+
+	for len(s.segments) > 0 {
+		s.removeOldest()
+	}
+}
+
+// readAt reads the record at an offset of a segment
+func (s *Spool) readAt(seg *segment, offset int64) (Record, int64, error) {
+	file, err := os.Open(s.segmentPath(seg.seq))
+	if err != nil {
+		return Record{}, 0, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer file.Close()
+
+	data, next, err := readRecord(file, offset, seg.size)
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return Record{}, 0, fmt.Errorf("failed to deserialize spool record: %w", err)
+	}
+
+	return record, next, nil
+}
+
+// readRecord reads the data of the record at an offset of a segment of size
+// bytes and returns the offset of the next record. It returns io.EOF at the
+// end of the segment.
+func readRecord(r io.ReaderAt, offset int64, size int64) ([]byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if n, err := r.ReadAt(header, offset); err != nil {
+		if errors.Is(err, io.EOF) && n == 0 {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, fmt.Errorf("failed to read spool record header: %w", io.ErrUnexpectedEOF)
+	}
+
+	// Check the length before allocating, a damaged header could claim up to 4 GiB
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if length > size-offset-recordHeaderSize {
+		return nil, 0, fmt.Errorf("failed to read spool record: %w", io.ErrUnexpectedEOF)
+	}
+
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, offset+recordHeaderSize); err != nil {
+		return nil, 0, fmt.Errorf("failed to read spool record: %w", io.ErrUnexpectedEOF)
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("spool record checksum mismatch")
+	}
+
+	return data, offset + recordHeaderSize + int64(len(data)), nil
+}
+
+// Len returns the number of spooled records
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats.Records
+}
+
+// Stats returns the counters of the spool
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Segments = len(s.segments)
+
+	return stats
+}
+
+// Close closes the segment being written and saves the read position
+func (s *Spool) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			s.logger.Error("Failed to close spool segment", zap.Error(err))
+		}
+		s.writer = nil
+	}
+
+	s.writeCursor()
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// readCursor returns the saved read position in a segment, or 0 if it was
+// saved for another segment
+func (s *Spool) readCursor(seq uint64) int64 {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFileName))
+	if err != nil {
+		return 0
+	}
+
+	var cursorSeq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &cursorSeq, &offset); err != nil || cursorSeq != seq {
+		return 0
+	}
+
+	return offset
+}
+
+// writeCursor saves the read position in the oldest segment
+func (s *Spool) writeCursor() {
+	cursorFilePath := filepath.Join(s.dir, cursorFileName)
+
+	if len(s.segments) == 0 {
+		if err := os.Remove(cursorFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.Error("Failed to remove spool cursor", zap.Error(err))
+		}
+		return
+	}
+
+	data := fmt.Sprintf("%d %d\n", s.segments[0].seq, s.readOffset)
+	if err := os.WriteFile(cursorFilePath, []byte(data), 0o644); err != nil {
+		s.logger.Error("Failed to write spool cursor", zap.Error(err))
+	}
+}
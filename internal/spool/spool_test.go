@@ -0,0 +1,311 @@
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+// openSpool opens a spool in dir with the default limits
+func openSpool(t *testing.T, dir string) *Spool {
+	t.Helper()
+
+	s, err := Open(&app.SpoolConfig{Dir: dir, MaxSizeMB: 256, SegmentSizeMB: 8, MaxAge: 24}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// appendRecords appends records with the topics topic-<from> to topic-<to>
+func appendRecords(t *testing.T, s *Spool, from, to int) {
+	t.Helper()
+
+	for i := from; i <= to; i++ {
+		record := Record{Time: time.Now(), Topic: fmt.Sprintf("topic-%d", i), Value: []byte(fmt.Sprintf("message %d", i))}
+		if err := s.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// replayTopics replays up to max records and returns their topics
+func replayTopics(t *testing.T, s *Spool, max int) []string {
+	t.Helper()
+
+	var topics []string
+	if _, err := s.Replay(max, func(record Record) error {
+		topics = append(topics, record.Topic)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return topics
+}
+
+func assertTopics(t *testing.T, got []string, from, to int) {
+	t.Helper()
+
+	var want []string
+	for i := from; i <= to; i++ {
+		want = append(want, fmt.Sprintf("topic-%d", i))
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReplayOrder(t *testing.T) {
+	s := openSpool(t, t.TempDir())
+	defer s.Close()
+
+	appendRecords(t, s, 1, 5)
+
+	// A failed send stops the replay and the record is replayed again
+	sendErr := errors.New("broker unavailable")
+	var topics []string
+	replayed, err := s.Replay(10, func(record Record) error {
+		if record.Topic == "topic-3" {
+			return sendErr
+		}
+		topics = append(topics, record.Topic)
+		return nil
+	})
+	if !errors.Is(err, sendErr) || replayed != 2 {
+		t.Fatalf("replayed %d records with error %v, want 2 and %v", replayed, err, sendErr)
+	}
+	assertTopics(t, topics, 1, 2)
+
+	appendRecords(t, s, 6, 7)
+	assertTopics(t, replayTopics(t, s, 10), 3, 7)
+
+	if s.Len() != 0 {
+		t.Errorf("spool holds %d records after replay, want 0", s.Len())
+	}
+	if stats := s.Stats(); stats.Segments != 0 || stats.Spooled != 7 || stats.Replayed != 7 {
+		t.Errorf("stats %+v, want no segments and 7 spooled and replayed records", stats)
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openSpool(t, dir)
+	appendRecords(t, s, 1, 5)
+	assertTopics(t, replayTopics(t, s, 2), 1, 2)
+	s.Close()
+
+	// The cursor skips the records replayed before the restart
+	s = openSpool(t, dir)
+	defer s.Close()
+
+	if s.Len() != 3 {
+		t.Fatalf("spool holds %d records after restart, want 3", s.Len())
+	}
+
+	appendRecords(t, s, 6, 6)
+	assertTopics(t, replayTopics(t, s, 10), 3, 6)
+}
+
+func TestTornTailRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openSpool(t, dir)
+	appendRecords(t, s, 1, 3)
+	s.Close()
+
+	// A crash left the header of a 100 byte record and part of its data
+	segmentPath := s.segmentPath(1)
+	info, err := os.Stat(segmentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torn := make([]byte, recordHeaderSize+10)
+	binary.BigEndian.PutUint32(torn[0:4], 100)
+	appendToFile(t, segmentPath, torn)
+
+	s = openSpool(t, dir)
+	defer s.Close()
+
+	if s.Len() != 3 {
+		t.Fatalf("spool holds %d records, want 3", s.Len())
+	}
+
+	// The torn record is truncated so new records are readable after it
+	if info2, err := os.Stat(segmentPath); err != nil || info2.Size() != info.Size() {
+		t.Fatalf("segment is not truncated to %d bytes: %v", info.Size(), err)
+	}
+
+	appendRecords(t, s, 4, 4)
+	assertTopics(t, replayTopics(t, s, 10), 1, 4)
+}
+
+func TestDamagedLengthIsNotAllocated(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openSpool(t, dir)
+	appendRecords(t, s, 1, 1)
+	s.Close()
+
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], 0xFFFFFFFF)
+	appendToFile(t, s.segmentPath(1), header)
+
+	file, err := os.Open(s.segmentPath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := info.Size() - recordHeaderSize
+	if _, _, err := readRecord(file, offset, info.Size()); err == nil {
+		t.Fatal("read a record longer than the segment")
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	t.Run("on open", func(t *testing.T) {
+		dir := t.TempDir()
+
+		s := openSpool(t, dir)
+		appendRecords(t, s, 1, 3)
+		s.Close()
+
+		corruptRecord(t, s.segmentPath(1), 1)
+
+		// The segment ends at the damaged record
+		s = openSpool(t, dir)
+		defer s.Close()
+
+		if s.Len() != 1 {
+			t.Fatalf("spool holds %d records, want 1", s.Len())
+		}
+		assertTopics(t, replayTopics(t, s, 10), 1, 1)
+	})
+
+	t.Run("on replay", func(t *testing.T) {
+		s := openSpool(t, t.TempDir())
+		defer s.Close()
+
+		appendRecords(t, s, 1, 3)
+		corruptRecord(t, s.segmentPath(1), 1)
+
+		// The rest of the damaged segment is dropped
+		assertTopics(t, replayTopics(t, s, 10), 1, 1)
+
+		if stats := s.Stats(); stats.DroppedCorrupt != 2 || stats.Records != 0 {
+			t.Errorf("stats %+v, want 2 corrupt records dropped and none left", stats)
+		}
+	})
+}
+
+func TestEvictBySize(t *testing.T) {
+	s := openSpool(t, t.TempDir())
+	defer s.Close()
+
+	// One record per segment and room for three, later records are a few
+	// bytes longer
+	appendRecords(t, s, 1, 1)
+	s.segmentBytes = 1
+	s.maxBytes = 3*s.Stats().Bytes + 16
+
+	appendRecords(t, s, 2, 10)
+
+	stats := s.Stats()
+	if stats.DroppedSize != 7 || stats.Records != 3 || stats.Segments != 3 {
+		t.Fatalf("stats %+v, want 7 records dropped and 3 left in 3 segments", stats)
+	}
+
+	assertTopics(t, replayTopics(t, s, 10), 8, 10)
+}
+
+func TestEvictByAge(t *testing.T) {
+	t.Run("segments", func(t *testing.T) {
+		s := openSpool(t, t.TempDir())
+		defer s.Close()
+
+		s.segmentBytes = 1
+		appendRecords(t, s, 1, 3)
+
+		// The first two segments were last written two days ago
+		old := time.Now().Add(-48 * time.Hour)
+		for seq := uint64(1); seq <= 2; seq++ {
+			if err := os.Chtimes(s.segmentPath(seq), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s.Expire(time.Now())
+
+		if stats := s.Stats(); stats.DroppedAge != 2 || stats.Records != 1 {
+			t.Fatalf("stats %+v, want 2 expired records dropped and 1 left", stats)
+		}
+		assertTopics(t, replayTopics(t, s, 10), 3, 3)
+	})
+
+	t.Run("records", func(t *testing.T) {
+		s := openSpool(t, t.TempDir())
+		defer s.Close()
+
+		if err := s.Append(Record{Time: time.Now().Add(-48 * time.Hour), Topic: "topic-0"}); err != nil {
+			t.Fatal(err)
+		}
+		appendRecords(t, s, 1, 2)
+
+		// Records older than the maximum age are dropped instead of replayed
+		assertTopics(t, replayTopics(t, s, 10), 1, 2)
+
+		if stats := s.Stats(); stats.DroppedAge != 1 {
+			t.Errorf("dropped %d expired records, want 1", stats.DroppedAge)
+		}
+	})
+}
+
+func appendToFile(t *testing.T, filePath string, data []byte) {
+	t.Helper()
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corruptRecord flips the last data byte of the n-th record of a segment
+func corruptRecord(t *testing.T, filePath string, n int) {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offset int
+	for i := 0; i <= n; i++ {
+		offset += recordHeaderSize + int(binary.BigEndian.Uint32(data[offset:offset+4]))
+	}
+	data[offset-1] ^= 0xFF
+
+	if err := os.WriteFile(filePath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,18 @@
+package workers
+
+import (
+	"errors"
+
+	"github.com/johandrevandeventer/devicesdb/models"
+)
+
+// ErrRecordNotFound is returned by device repositories when no device matches
+var ErrRecordNotFound = errors.New("record not found")
+
+// DeviceRepository looks up customers and devices
+type DeviceRepository interface {
+	GetAllCustomers() ([]models.Customer, error)
+	GetAllDevices() ([]models.Device, error)
+	GetDevicesByControllerIdentifier(controllerIdentifier string) ([]models.Device, error)
+	GetDeviceByDeviceIdentifier(deviceIdentifier string) (models.Device, error)
+}
@@ -0,0 +1,47 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+// DeviceNotFoundError is returned when a payload refers to a device identifier
+// that is not registered in the devices database
+type DeviceNotFoundError struct {
+	SiteName         string
+	DeviceName       string
+	DeviceIdentifier string
+
+	// Set by the worker once the customer topic is known
+	Customer string
+	Topic    string
+}
+
+func (e *DeviceNotFoundError) Error() string {
+	return fmt.Sprintf("device not found: %s -> %s -> %s", e.SiteName, e.DeviceName, e.DeviceIdentifier)
+}
+
+// IgnoredError is returned when a controller or device matches an ignore rule
+type IgnoredError struct {
+	Kind       string
+	Identifier string
+	Rule       types.IgnoreRule
+}
+
+func (e *IgnoredError) Error() string {
+	return fmt.Sprintf("%s is ignored: %s", e.Kind, e.Identifier)
+}
+
+// OwnershipError is returned when a device resolved from a payload does not
+// belong to the customer of the topic or the site named in the payload
+type OwnershipError struct {
+	DeviceIdentifier string
+	Field            string
+	Expected         string
+	Actual           string
+}
+
+func (e *OwnershipError) Error() string {
+	return fmt.Sprintf("device %s belongs to %s %s, not %s", e.DeviceIdentifier, e.Field, e.Actual, e.Expected)
+}
@@ -0,0 +1,250 @@
+package ignored
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
+)
+
+const (
+	lockTimeout  = 5 * time.Second
+	lockStaleAge = 1 * time.Minute
+)
+
+var regexCache sync.Map
+
+// Scope describes the message a rule is matched against
+type Scope struct {
+	Customer   string
+	Site       string
+	Identifier string
+}
+
+// Load reads the ignore file, converting legacy identifier lists into rules
+func Load(filePath string) (*types.IgnoredControllersAndDevices, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	var list types.IgnoredControllersAndDevices
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	for _, controller := range list.IgnoredControllers {
+		list.Rules = append(list.Rules, legacyRule(types.IgnoreKindController, controller))
+	}
+
+	for _, device := range list.IgnoredDevices {
+		list.Rules = append(list.Rules, legacyRule(types.IgnoreKindDevice, device))
+	}
+
+	list.IgnoredControllers = nil
+	list.IgnoredDevices = nil
+
+	return &list, nil
+}
+
+// legacyRule converts an entry from the legacy identifier lists into a rule
+func legacyRule(kind string, identifier string) types.IgnoreRule {
+	return types.IgnoreRule{
+		ID:      fmt.Sprintf("%s-%s", kind, identifier),
+		Kind:    kind,
+		Pattern: identifier,
+		Match:   types.IgnoreMatchExact,
+	}
+}
+
+// Update loads the ignore file, applies fn and saves the result while holding
+// a lock file, so concurrent edits cannot overwrite each other
+func Update(filePath string, fn func(list *types.IgnoredControllersAndDevices) error) error {
+	unlock, err := lock(filePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	list, err := Load(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		list, err = &types.IgnoredControllersAndDevices{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := fn(list); err != nil {
+		return err
+	}
+
+	for _, rule := range list.Rules {
+		if err := Validate(rule); err != nil {
+			return fmt.Errorf("invalid rule %s: %w", rule.ID, err)
+		}
+	}
+
+	return save(filePath, list)
+}
+
+// save atomically writes the ignore file
+func save(filePath string, list *types.IgnoredControllersAndDevices) error {
+	if list.Rules == nil {
+		list.Rules = []types.IgnoreRule{}
+	}
+
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	tmpFilePath := filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err := os.Rename(tmpFilePath, filePath); err != nil {
+		return fmt.Errorf("error replacing file: %w", err)
+	}
+
+	return nil
+}
+
+// lock creates a lock file next to the ignore file and returns a function that removes it
+func lock(filePath string) (func(), error) {
+	lockFilePath := filePath + ".lock"
+
+	if err := os.MkdirAll(filepath.Dir(lockFilePath), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating directory: %w", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		file, err := os.OpenFile(lockFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockFilePath) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating lock file: %w", err)
+		}
+
+		// Remove locks left behind by a crashed editor
+		if info, statErr := os.Stat(lockFilePath); statErr == nil && time.Since(info.ModTime()) > lockStaleAge {
+			os.Remove(lockFilePath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("ignore file is locked: %s", lockFilePath)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// NewRuleID generates a short identifier for a new rule
+func NewRuleID() string {
+	return strings.Split(coreutils.GenerateUUID().String(), "-")[0]
+}
+
+// Validate checks that a rule is well formed
+func Validate(rule types.IgnoreRule) error {
+	switch rule.Kind {
+	case types.IgnoreKindController, types.IgnoreKindDevice:
+	default:
+		return fmt.Errorf("unknown kind: %s", rule.Kind)
+	}
+
+	if rule.Pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+
+	switch rule.Match {
+	case types.IgnoreMatchExact:
+	case types.IgnoreMatchGlob, "":
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern: %w", err)
+		}
+	case types.IgnoreMatchRegex:
+		if _, err := compileRegex(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid regex pattern: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown match type: %s", rule.Match)
+	}
+
+	return nil
+}
+
+// IsExpired reports whether a rule has expired
+func IsExpired(rule types.IgnoreRule, now time.Time) bool {
+	return rule.ExpiresAt != nil && !now.Before(*rule.ExpiresAt)
+}
+
+// Matches reports whether an active rule applies to the given scope
+func Matches(rule types.IgnoreRule, scope Scope, now time.Time) bool {
+	if IsExpired(rule, now) {
+		return false
+	}
+
+	if rule.Customer != "" && !strings.EqualFold(rule.Customer, scope.Customer) {
+		return false
+	}
+
+	if rule.Site != "" && !strings.EqualFold(rule.Site, scope.Site) {
+		return false
+	}
+
+	switch rule.Match {
+	case types.IgnoreMatchExact:
+		return rule.Pattern == scope.Identifier
+	case types.IgnoreMatchRegex:
+		re, err := compileRegex(rule.Pattern)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(scope.Identifier)
+	default:
+		matched, err := path.Match(rule.Pattern, scope.Identifier)
+		return err == nil && matched
+	}
+}
+
+// Find returns the first active rule of the given kind that applies to the scope
+func Find(list *types.IgnoredControllersAndDevices, kind string, scope Scope, now time.Time) (*types.IgnoreRule, bool) {
+	for i := range list.Rules {
+		if list.Rules[i].Kind == kind && Matches(list.Rules[i], scope, now) {
+			return &list.Rules[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// compileRegex compiles a regex pattern once and caches it. Patterns are
+// anchored so that, like exact and glob rules, they match the whole identifier.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+
+	regexCache.Store(pattern, re)
+
+	return re, nil
+}
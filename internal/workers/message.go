@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// MessageContext identifies the message being worked on. Its fields are filled
+// in as they become known and are added to every log line of loggers created
+// with Logger, so all logs on a message's path can be correlated by its ID.
+type MessageContext struct {
+	ID               string
+	Customer         string
+	DeviceIdentifier string
+	Decoder          string
+}
+
+// NewMessageContext creates a new MessageContext for the payload ID
+func NewMessageContext(id string) *MessageContext {
+	return &MessageContext{ID: id}
+}
+
+// Fields returns the known message fields as log fields
+func (m *MessageContext) Fields() []zap.Field {
+	fields := make([]zap.Field, 0, 4)
+	fields = append(fields, zap.String("id", m.ID))
+
+	if m.Customer != "" {
+		fields = append(fields, zap.String("customer", m.Customer))
+	}
+	if m.DeviceIdentifier != "" {
+		fields = append(fields, zap.String("deviceID", m.DeviceIdentifier))
+	}
+	if m.Decoder != "" {
+		fields = append(fields, zap.String("decoder", m.Decoder))
+	}
+
+	return fields
+}
+
+// Logger returns a logger that adds the message fields to every entry. The fields
+// are read when the entry is written, so fields set later are included as well.
+func (m *MessageContext) Logger(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &messageCore{Core: core, msg: m}
+	}))
+}
+
+// messageCore adds the fields of a message to the entries of the wrapped core
+type messageCore struct {
+	zapcore.Core
+	msg *MessageContext
+}
+
+func (c *messageCore) With(fields []zapcore.Field) zapcore.Core {
+	return &messageCore{Core: c.Core.With(fields), msg: c.msg}
+}
+
+func (c *messageCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(entry.Level) {
+		return checked
+	}
+
+	return c.Core.With(c.msg.Fields()).Check(entry, checked)
+}
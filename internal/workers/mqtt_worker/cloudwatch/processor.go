@@ -1,16 +1,20 @@
 package cloudwatch
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"slices"
 	"strings"
 	"time"
 
 	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/mqtt_worker/cloudwatch/powermeter"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -18,22 +22,33 @@ const (
 	DeviceTypePowermeter = "powermeter"
 )
 
-func Processor(msg payload.Payload, logger *zap.Logger) (MessageInfo *types.MessageInfo, err error) {
+func Processor(ctx context.Context, msg payload.Payload, topicInfo *types.TopicInfo, deviceRepository workers.DeviceRepository, msgCtx *workers.MessageContext, logger *zap.Logger) (MessageInfo *types.MessageInfo, err error) {
 	var cloudWatchInfo CloudWatch
 	if err := json.Unmarshal(msg.Message, &cloudWatchInfo); err != nil {
 		return MessageInfo, fmt.Errorf("failed to unmarshal payload: %w", err)
 	}
 
 	var siteName string
-	// var SiteIdentifier string
+	var siteIdentifier string
 	var deviceIdentifier string
 	var deviceName string
 
 	siteName = cloudWatchInfo.SiteName
-	// SiteIdentifier = cloudWatchInfo.SiteIdentifier
+	siteIdentifier = cloudWatchInfo.SiteIdentifier
 	deviceIdentifier = cloudWatchInfo.DeviceIdentifier
 	deviceName = cloudWatchInfo.DeviceName
 
+	// Fall back to the topic when the payload does not name the site or controller
+	if siteName == "" {
+		siteName = topicInfo.Site
+	}
+
+	if deviceIdentifier == "" {
+		deviceIdentifier = topicInfo.Controller
+	}
+
+	customer := topicInfo.Customer
+
 	var controllerID string
 	var deviceID string
 
@@ -41,37 +56,34 @@ func Processor(msg payload.Payload, logger *zap.Logger) (MessageInfo *types.Mess
 
 	logger.Debug("Processing controller", zap.String("controllerID", controllerID))
 
-	ignoredControllers, err := workers.GetIgnoredControllers()
-	if err != nil {
-		return MessageInfo, fmt.Errorf("error getting ignored controllers: %w", err)
-	}
-
-	if slices.Contains(ignoredControllers, controllerID) {
-		return MessageInfo, fmt.Errorf("controller is ignored: %s", controllerID)
+	if err := workers.CheckIgnored(types.IgnoreKindController, customer, siteName, controllerID); err != nil {
+		return MessageInfo, err
 	}
 
 	deviceID = controllerID
+	msgCtx.DeviceIdentifier = deviceID
 
-	logger.Debug("Processing device", zap.String("deviceID", deviceID))
-
-	ignoredDevices, err := workers.GetIgnoredDevices()
-	if err != nil {
-		return MessageInfo, fmt.Errorf("error getting ignored devices: %w", err)
-	}
+	logger.Debug("Processing device")
 
-	if slices.Contains(ignoredDevices, deviceID) {
-		return MessageInfo, fmt.Errorf("device is ignored: %s", deviceID)
+	if err := workers.CheckIgnored(types.IgnoreKindDevice, customer, siteName, deviceID); err != nil {
+		return MessageInfo, err
 	}
 
-	device, err := workers.GetDevicesByDeviceIdentifier(deviceID)
+	_, lookupSpan := tracing.Start(ctx, "device_lookup", trace.WithAttributes(attribute.String("device.identifier", deviceID)))
+	device, err := deviceRepository.GetDeviceByDeviceIdentifier(deviceID)
+	tracing.End(lookupSpan, err)
 	if err != nil {
-		if strings.Contains(err.Error(), "record not found") {
-			return MessageInfo, fmt.Errorf("device not found: %s -> %s -> %s", siteName, deviceName, deviceID)
+		if errors.Is(err, workers.ErrRecordNotFound) {
+			return MessageInfo, &workers.DeviceNotFoundError{SiteName: siteName, DeviceName: deviceName, DeviceIdentifier: deviceID}
 		}
 
 		return MessageInfo, fmt.Errorf("error getting device by device ID - %s: %w", deviceID, err)
 	}
 
+	if err := workers.CheckDeviceOwnership(device, customer, siteIdentifier); err != nil {
+		return MessageInfo, err
+	}
+
 	deviceType := device.DeviceType
 	deviceTypeLower := strings.ToLower(deviceType)
 	t := cloudWatchInfo.Timestamp
@@ -3,6 +3,7 @@ package mqttworker
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
 )
@@ -27,6 +28,17 @@ func (d *Decoder) RegisterDecoder(
 	d.decoders[name] = decoder
 }
 
+// Names returns the names of the registered decoders
+func (d *Decoder) Names() []string {
+	names := make([]string, 0, len(d.decoders))
+	for name := range d.decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // DecodePayload processes a message
 func (d *Decoder) DecodePayload(payload []byte) (decodedPayloadInfo *types.DecodedPayloadInfo, err error) {
 	// Try registered decoders first
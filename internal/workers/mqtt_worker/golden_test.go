@@ -0,0 +1,180 @@
+package mqttworker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/devicerepo"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// Regenerate the golden files with:
+//
+//	go test ./internal/workers/mqtt_worker/ -run TestGolden -update
+var update = flag.Bool("update", false, "update the golden files")
+
+const goldenDir = "testdata/golden"
+
+// goldenInput is a payload as received from the MQTT bridge
+type goldenInput struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// goldenOutput is the expected result of running a payload through the worker
+type goldenOutput struct {
+	Error string              `json:"error,omitempty"`
+	Data  []*types.DataStruct `json:"data,omitempty"`
+}
+
+// goldenDevice is a device in the in-memory device repository
+type goldenDevice struct {
+	CustomerID           uuid.UUID `json:"customer_id"`
+	Customer             string    `json:"customer"`
+	SiteID               uuid.UUID `json:"site_id"`
+	Site                 string    `json:"site"`
+	Controller           string    `json:"controller"`
+	ControllerIdentifier string    `json:"controller_identifier"`
+	DeviceType           string    `json:"device_type"`
+	DeviceIdentifier     string    `json:"device_identifier"`
+	DeviceName           string    `json:"device_name"`
+}
+
+// loadDeviceRepository creates an in-memory device repository with the devices
+// in testdata/golden/devices.json
+func loadDeviceRepository(t *testing.T) *devicerepo.Memory {
+	t.Helper()
+
+	var fixtures []goldenDevice
+	readJSON(t, filepath.Join(goldenDir, "devices.json"), &fixtures)
+
+	var devices []models.Device
+	for _, fixture := range fixtures {
+		customer := models.Customer{ID: fixture.CustomerID, Name: fixture.Customer}
+		devices = append(devices, models.Device{
+			Controller:           fixture.Controller,
+			ControllerIdentifier: fixture.ControllerIdentifier,
+			DeviceType:           fixture.DeviceType,
+			DeviceIdentifier:     fixture.DeviceIdentifier,
+			DeviceName:           fixture.DeviceName,
+			SiteID:               fixture.SiteID,
+			Site:                 models.Site{ID: fixture.SiteID, Name: fixture.Site, CustomerID: customer.ID, Customer: customer},
+		})
+	}
+
+	return devicerepo.NewMemory(devices)
+}
+
+// TestGolden runs every case in testdata/golden/cases through the worker and
+// compares the Pre and Post data with the case's expected.json
+func TestGolden(t *testing.T) {
+	devices := loadDeviceRepository(t)
+	workers.SetIgnoredFilePath(filepath.Join(goldenDir, "ignored.json"))
+
+	topicMatcher, err := workers.NewTopicMatcher([]string{"Rubicon/mqtt/{customer}/#"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases, err := os.ReadDir(filepath.Join(goldenDir, "cases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		if !c.IsDir() {
+			continue
+		}
+
+		t.Run(c.Name(), func(t *testing.T) {
+			caseDir := filepath.Join(goldenDir, "cases", c.Name())
+
+			var input goldenInput
+			readJSON(t, filepath.Join(caseDir, "input.json"), &input)
+
+			got := runGoldenCase(t, NewWorker(zap.NewNop(), topicMatcher, devices), c.Name(), input)
+
+			expectedFilePath := filepath.Join(caseDir, "expected.json")
+			if *update {
+				if err := os.WriteFile(expectedFilePath, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			expected, err := os.ReadFile(expectedFilePath)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+
+			if !bytes.Equal(expected, got) {
+				t.Errorf("output does not match %s, run with -update to accept it\n--- expected\n%s\n--- got\n%s", expectedFilePath, expected, got)
+			}
+		})
+	}
+}
+
+// runGoldenCase runs a payload through the worker and returns the serialized result
+func runGoldenCase(t *testing.T, worker *Worker, name string, input goldenInput) []byte {
+	t.Helper()
+
+	// Fixed IDs and times keep the output stable
+	id := uuid.NewSHA1(uuid.NameSpaceOID, []byte(name))
+	p := payload.Payload{
+		ID:               id,
+		MqttTopic:        input.Topic,
+		Message:          input.Payload,
+		MessageTimestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	msg, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output goldenOutput
+	messageInfo, err := worker.RunWorker(context.Background(), msg, workers.NewMessageContext(id.String()))
+	if err != nil {
+		output.Error = err.Error()
+	} else {
+		for _, device := range messageInfo.Devices {
+			output.Data = append(output.Data, device.RawDataStruct(), device.ProcessedDataStruct())
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func readJSON(t *testing.T, filePath string, v any) {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatalf("failed to decode %s: %v", filePath, err)
+	}
+}
@@ -1,12 +1,17 @@
 package mqttworker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/tracing"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/mqtt_worker/cloudwatch"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -16,53 +21,109 @@ const (
 )
 
 type Worker struct {
-	decoder   *Decoder
-	processor *Processor
-	logger    *zap.Logger
+	decoder      *Decoder
+	processor    *Processor
+	topicMatcher *workers.TopicMatcher
+	devices      workers.DeviceRepository
+	logger       *zap.Logger
 }
 
-func NewWorker(logger *zap.Logger) *Worker {
+func NewWorker(logger *zap.Logger, topicMatcher *workers.TopicMatcher, devices workers.DeviceRepository) *Worker {
 	decoder := NewDecoder()
-	processor := NewProcessor(logger)
+	processor := NewProcessor(logger, devices)
 
 	// Priority 1
 	decoder.RegisterDecoder("CloudWatch", cloudwatch.Decoder)
 	processor.RegisterProcessor("CloudWatch", cloudwatch.Processor)
 
 	return &Worker{
-		decoder:   decoder,
-		processor: processor,
-		logger:    logger,
+		decoder:      decoder,
+		processor:    processor,
+		topicMatcher: topicMatcher,
+		devices:      devices,
+		logger:       logger,
 	}
 }
 
-func (w *Worker) RunWorker(msg []byte) (messageInfo *types.MessageInfo, err error) {
+// Decoders returns the names of the registered decoders
+func (w *Worker) Decoders() []string {
+	return w.decoder.Names()
+}
+
+// Processors returns the names of the registered processors
+func (w *Worker) Processors() []string {
+	return w.processor.Names()
+}
+
+// RunWorker decodes and processes a message. Every log line includes the fields
+// of msgCtx, which are filled in as the message is worked on.
+func (w *Worker) RunWorker(ctx context.Context, msg []byte, msgCtx *workers.MessageContext) (messageInfo *types.MessageInfo, err error) {
 	p, err := payload.Deserialize(msg)
 	if err != nil {
 		return messageInfo, fmt.Errorf("failed to deserialize data: %w", err)
 	}
 
-	w.logger.Info("Running worker", zap.String("worker", WorkerTitle), zap.String("topic", p.MqttTopic), zap.String("id", p.ID.String()))
+	logger := msgCtx.Logger(w.logger)
 
-	trimmedTopic := workers.TrimPrefix(p.MqttTopic, MqttTopicPrefix)
-	w.logger.Debug("Validating customer", zap.String("topic", trimmedTopic))
+	logger.Info("Running worker", zap.String("worker", WorkerTitle), zap.String("topic", p.MqttTopic))
 
-	customer, err := workers.GetValidCustomer(trimmedTopic)
+	topicInfo, err := w.validateCustomer(ctx, p.MqttTopic, logger)
 	if err != nil {
 		return messageInfo, fmt.Errorf("customer validation failed: %w", err)
 	}
 
+	msgCtx.Customer = topicInfo.Customer
+	customer := topicInfo.Customer
+
+	_, decodeSpan := tracing.Start(ctx, "decode")
 	decodedPayloadInfo, err := w.decoder.DecodePayload(p.Message)
+	if err == nil {
+		decodeSpan.SetAttributes(attribute.String("decoder", decodedPayloadInfo.Type))
+	}
+	tracing.End(decodeSpan, err)
 	if err != nil {
 		return messageInfo, fmt.Errorf("failed to decode payload: %w", err)
 	}
 
-	w.logger.Debug(fmt.Sprintf("%s :: %s", WorkerTitle, customer))
+	msgCtx.Decoder = decodedPayloadInfo.Type
+
+	logger.Debug(fmt.Sprintf("%s :: %s", WorkerTitle, customer))
 
-	messageInfo, err = w.processor.ProcessPayload(decodedPayloadInfo.Type, *p)
+	processCtx, processSpan := tracing.Start(ctx, "process", trace.WithAttributes(attribute.String("processor", decodedPayloadInfo.Type)))
+	messageInfo, err = w.processor.ProcessPayload(processCtx, decodedPayloadInfo.Type, *p, topicInfo, msgCtx)
+	tracing.End(processSpan, err)
 	if err != nil {
+		var deviceNotFoundErr *workers.DeviceNotFoundError
+		if errors.As(err, &deviceNotFoundErr) {
+			deviceNotFoundErr.Customer = customer
+			deviceNotFoundErr.Topic = p.MqttTopic
+		}
+
 		return messageInfo, fmt.Errorf("failed to process payload: %w", err)
 	}
 
 	return messageInfo, nil
 }
+
+// validateCustomer extracts the customer from the topic and resolves it in the device repository
+func (w *Worker) validateCustomer(ctx context.Context, topic string, logger *zap.Logger) (topicInfo *types.TopicInfo, err error) {
+	_, span := tracing.Start(ctx, "validate_customer")
+	defer func() { tracing.End(span, err) }()
+
+	topicInfo, err = w.topicMatcher.Parse(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+
+	logger.Debug("Validating customer", zap.String("topic", topic), zap.String("topicCustomer", topicInfo.Customer))
+
+	customer, err := workers.GetValidCustomer(w.devices, topicInfo.Customer)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("customer", customer))
+	topicInfo.Customer = customer
+
+	return topicInfo, nil
+}
@@ -1,9 +1,12 @@
 package mqttworker
 
 import (
+	"context"
 	"fmt"
+	"sort"
 
 	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
 	"go.uber.org/zap"
 )
@@ -11,34 +14,47 @@ import (
 // Processor handles payload identification
 type Processor struct {
 	logger     *zap.Logger
-	processors map[string]func(payload.Payload, *zap.Logger) (*types.MessageInfo, error)
+	devices    workers.DeviceRepository
+	processors map[string]func(context.Context, payload.Payload, *types.TopicInfo, workers.DeviceRepository, *workers.MessageContext, *zap.Logger) (*types.MessageInfo, error)
 }
 
 // NewProcessor creates a new Processor with registered processors
-func NewProcessor(logger *zap.Logger) *Processor {
+func NewProcessor(logger *zap.Logger, devices workers.DeviceRepository) *Processor {
 	return &Processor{
 		logger:     logger,
-		processors: make(map[string]func(payload.Payload, *zap.Logger) (*types.MessageInfo, error)),
+		devices:    devices,
+		processors: make(map[string]func(context.Context, payload.Payload, *types.TopicInfo, workers.DeviceRepository, *workers.MessageContext, *zap.Logger) (*types.MessageInfo, error)),
 	}
 }
 
 // RegisterProcessor adds a new payload processor
 func (d *Processor) RegisterProcessor(
 	name string,
-	processor func(payload.Payload, *zap.Logger) (*types.MessageInfo, error),
+	processor func(context.Context, payload.Payload, *types.TopicInfo, workers.DeviceRepository, *workers.MessageContext, *zap.Logger) (*types.MessageInfo, error),
 ) {
 	d.processors[name] = processor
 }
 
+// Names returns the names of the registered processors
+func (d *Processor) Names() []string {
+	names := make([]string, 0, len(d.processors))
+	for name := range d.processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // ProcessPayload processes a message
-func (d *Processor) ProcessPayload(name string, msg payload.Payload) (MessageInfo *types.MessageInfo, err error) {
+func (d *Processor) ProcessPayload(ctx context.Context, name string, msg payload.Payload, topicInfo *types.TopicInfo, msgCtx *workers.MessageContext) (MessageInfo *types.MessageInfo, err error) {
 	processor := d.processors[string(name)]
 
 	if processor == nil {
 		return MessageInfo, fmt.Errorf("unknown processor: %s", name)
 	}
 
-	MessageInfo, err = processor(msg, d.logger)
+	MessageInfo, err = processor(ctx, msg, topicInfo, d.devices, msgCtx, msgCtx.Logger(d.logger))
 	if err != nil {
 		return MessageInfo, err
 	}
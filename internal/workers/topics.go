@@ -0,0 +1,152 @@
+package workers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
+)
+
+// TopicTemplate is an MQTT topic pattern with named variables, e.g.
+// "Rubicon/mqtt/{customer}/{site}/{controller}/#". A "{name}" or "+" segment
+// matches exactly one level and a trailing "#" matches one or more remaining
+// levels, so "Rubicon/mqtt/{customer}/#" does not match "Rubicon/mqtt/acme".
+type TopicTemplate struct {
+	template string
+	segments []string
+}
+
+// ParseTopicTemplate parses and validates a topic template
+func ParseTopicTemplate(template string) (*TopicTemplate, error) {
+	segments := strings.Split(template, "/")
+
+	hasCustomer := false
+	for i, segment := range segments {
+		switch {
+		case segment == "#":
+			if i != len(segments)-1 {
+				return nil, fmt.Errorf("invalid topic template %s: # must be the last segment", template)
+			}
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name := segment[1 : len(segment)-1]
+			if name == "" {
+				return nil, fmt.Errorf("invalid topic template %s: empty variable name", template)
+			}
+			if name == "customer" {
+				hasCustomer = true
+			}
+		case strings.ContainsAny(segment, "{}#+") && segment != "+":
+			return nil, fmt.Errorf("invalid topic template %s: invalid segment %s", template, segment)
+		}
+	}
+
+	if !hasCustomer {
+		return nil, fmt.Errorf("invalid topic template %s: missing {customer} variable", template)
+	}
+
+	return &TopicTemplate{
+		template: template,
+		segments: segments,
+	}, nil
+}
+
+// String returns the template as configured
+func (t *TopicTemplate) String() string {
+	return t.template
+}
+
+// Match matches a topic against the template and returns the extracted variables
+func (t *TopicTemplate) Match(topic string) (map[string]string, bool) {
+	levels := strings.Split(topic, "/")
+	variables := make(map[string]string)
+
+	for i, segment := range t.segments {
+		if segment == "#" {
+			// "#" matches one or more levels
+			if i >= len(levels) {
+				return nil, false
+			}
+			return variables, true
+		}
+
+		if i >= len(levels) {
+			return nil, false
+		}
+
+		switch {
+		case segment == "+":
+		case strings.HasPrefix(segment, "{"):
+			if levels[i] == "" {
+				return nil, false
+			}
+			variables[segment[1:len(segment)-1]] = levels[i]
+		case segment != levels[i]:
+			return nil, false
+		}
+	}
+
+	if len(levels) != len(t.segments) {
+		return nil, false
+	}
+
+	return variables, true
+}
+
+// TopicMatcher extracts topic variables using the first matching template and
+// resolves customer aliases
+type TopicMatcher struct {
+	templates       []*TopicTemplate
+	customerAliases map[string]string
+}
+
+// NewTopicMatcher creates a new TopicMatcher. Aliases map customer names used
+// in topics to customer names in the devices database.
+func NewTopicMatcher(templates []string, customerAliases map[string]string) (*TopicMatcher, error) {
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("no topic templates configured")
+	}
+
+	m := &TopicMatcher{
+		customerAliases: make(map[string]string, len(customerAliases)),
+	}
+
+	for _, template := range templates {
+		t, err := ParseTopicTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		m.templates = append(m.templates, t)
+	}
+
+	for alias, customer := range customerAliases {
+		m.customerAliases[strings.ToLower(alias)] = customer
+	}
+
+	return m, nil
+}
+
+// Parse matches a topic against the templates and returns the topic information
+func (m *TopicMatcher) Parse(topic string) (*types.TopicInfo, error) {
+	for _, t := range m.templates {
+		variables, ok := t.Match(topic)
+		if !ok {
+			continue
+		}
+
+		customer := variables["customer"]
+		if alias, ok := m.customerAliases[strings.ToLower(customer)]; ok {
+			customer = alias
+		}
+
+		return &types.TopicInfo{
+			Topic:      topic,
+			Template:   t.String(),
+			Customer:   customer,
+			Site:       variables["site"],
+			Controller: variables["controller"],
+			Variables:  variables,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid topic: %s", topic)
+}
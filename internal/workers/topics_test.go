@@ -0,0 +1,41 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTopicTemplateMatch(t *testing.T) {
+	tests := []struct {
+		template  string
+		topic     string
+		variables map[string]string
+	}{
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Rubicon/mqtt/acme/CW-1001", variables: map[string]string{"customer": "acme"}},
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Rubicon/mqtt/acme/head-office/CW-1001", variables: map[string]string{"customer": "acme"}},
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Rubicon/mqtt/acme/", variables: map[string]string{"customer": "acme"}},
+
+		// "#" needs at least one level after the customer
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Rubicon/mqtt/acme"},
+
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Rubicon/mqtt"},
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Rubicon/mqtt//CW-1001"},
+		{template: "Rubicon/mqtt/{customer}/#", topic: "Other/mqtt/acme/CW-1001"},
+
+		{template: "Rubicon/{customer}/+/{controller}", topic: "Rubicon/acme/head-office/CW-1000", variables: map[string]string{"customer": "acme", "controller": "CW-1000"}},
+		{template: "Rubicon/{customer}/+/{controller}", topic: "Rubicon/acme/head-office"},
+		{template: "Rubicon/{customer}/+/{controller}", topic: "Rubicon/acme/head-office/CW-1000/CW-1001"},
+	}
+
+	for _, tt := range tests {
+		template, err := ParseTopicTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		variables, ok := template.Match(tt.topic)
+		if ok != (tt.variables != nil) || fmt.Sprint(variables) != fmt.Sprint(tt.variables) {
+			t.Errorf("matching %s against %s returned %v, %t, want %v", tt.topic, tt.template, variables, ok, tt.variables)
+		}
+	}
+}
@@ -12,10 +12,57 @@ type DecodedPayloadInfo struct {
 }
 
 type IgnoredControllersAndDevices struct {
-	IgnoredControllers []string `json:"ignored_controllers"`
-	IgnoredDevices     []string `json:"ignored_devices"`
+	// Legacy exact identifier lists, converted to rules when loaded
+	IgnoredControllers []string `json:"ignored_controllers,omitempty"`
+	IgnoredDevices     []string `json:"ignored_devices,omitempty"`
+
+	Rules []IgnoreRule `json:"rules"`
+}
+
+// Ignore rule kinds
+const (
+	IgnoreKindController = "controller"
+	IgnoreKindDevice     = "device"
+)
+
+// Ignore rule match types
+const (
+	IgnoreMatchExact = "exact"
+	IgnoreMatchGlob  = "glob"
+	IgnoreMatchRegex = "regex"
+)
+
+// IgnoreRule mutes a controller or device, optionally scoped to a customer or
+// site and optionally until an expiry time
+type IgnoreRule struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"`
+	Pattern   string     `json:"pattern"`
+	Match     string     `json:"match"`
+	Customer  string     `json:"customer,omitempty"`
+	Site      string     `json:"site,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// TopicInfo holds the variables extracted from an MQTT topic
+type TopicInfo struct {
+	Topic      string            `json:"topic"`
+	Template   string            `json:"template"`
+	Customer   string            `json:"customer"`
+	Site       string            `json:"site,omitempty"`
+	Controller string            `json:"controller,omitempty"`
+	Variables  map[string]string `json:"variables"`
 }
 
+// Data states of a DataStruct
+const (
+	DataStatePre  = "Pre"
+	DataStatePost = "Post"
+)
+
 type DataStruct struct {
 	State                string
 	CustomerID           uuid.UUID
@@ -67,3 +114,30 @@ type Device struct {
 	ProcessedData        map[string]any
 	Timestamp            time.Time
 }
+
+// RawDataStruct returns the raw data of the device, before processing
+func (d *Device) RawDataStruct() *DataStruct {
+	return d.dataStruct(DataStatePre, d.RawData)
+}
+
+// ProcessedDataStruct returns the processed data of the device
+func (d *Device) ProcessedDataStruct() *DataStruct {
+	return d.dataStruct(DataStatePost, d.ProcessedData)
+}
+
+func (d *Device) dataStruct(state string, data map[string]any) *DataStruct {
+	return &DataStruct{
+		State:                state,
+		CustomerID:           d.CustomerID,
+		CustomerName:         d.CustomerName,
+		SiteID:               d.SiteID,
+		SiteName:             d.SiteName,
+		Controller:           d.Controller,
+		DeviceType:           d.DeviceType,
+		ControllerIdentifier: d.ControllerIdentifier,
+		DeviceName:           d.DeviceName,
+		DeviceIdentifier:     d.DeviceIdentifier,
+		Data:                 data,
+		Timestamp:            d.Timestamp,
+	}
+}
@@ -5,14 +5,24 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/johandrevandeventer/devicesdb"
 	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/johandrevandeventer/mqtt-worker/internal/workers/ignored"
 	"github.com/johandrevandeventer/mqtt-worker/internal/workers/types"
 )
 
+var ignoredCache = struct {
+	mu       sync.Mutex
+	filePath string
+	list     *types.IgnoredControllersAndDevices
+	modTime  time.Time
+}{
+	filePath: "./internal/workers/ignored/ignored.json",
+}
+
 type Payload struct {
 	MqttTopic        string    `json:"mqtt_topic"`
 	Message          []byte    `json:"message"`
@@ -38,146 +48,118 @@ func TrimPrefix(s, prefix string) string {
 	return s[len(prefix):]
 }
 
-func getCustomerFromTopic(topic string) (string, error) {
-	// Split the topic
-	topicParts := strings.Split(topic, "/")
-	if len(topicParts) < 2 {
-		return "", fmt.Errorf("invalid topic: %s", topic)
-	}
-
-	// Return the customer
-	return topicParts[0], nil
-}
-
-// Helper function to get database instance
-// GetDBInstance returns the database instance or handles the error.
-func getDBInstance() (*devicesdb.BMS_DB, error) {
-	bmsDB, err := devicesdb.GetDB()
-	if err != nil {
-		return nil, err
-	}
-	return bmsDB, nil
-}
-
-// Helper function to get all customers
-func GetAllCustomers() ([]models.Customer, error) {
-	bmsDB, err := getDBInstance()
+// Helper function to validate and retrieve customer
+func GetValidCustomer(devices DeviceRepository, customer string) (string, error) {
+	customers, err := devices.GetAllCustomers()
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("failed to get customers: %w", err)
 	}
 
-	var customers []models.Customer
-	if err := bmsDB.DB.Find(&customers).Error; err != nil {
-		return nil, fmt.Errorf("failed to get customers: %w", err)
+	for _, c := range customers {
+		if strings.EqualFold(c.Name, customer) {
+			return c.Name, nil
+		}
 	}
 
-	return customers, nil
+	return "", fmt.Errorf("customer not found: %s", customer)
 }
 
-// Helper function to get all devices
-func GetAllDevices() ([]models.Device, error) {
-	bmsDB, err := getDBInstance()
-	if err != nil {
-		return nil, err
-	}
-
-	var devices []models.Device
-	if err := bmsDB.DB.Find(&devices).Error; err != nil {
-		return nil, fmt.Errorf("failed to get devices: %w", err)
+// Helper function to check that a device belongs to the topic customer and the payload site
+func CheckDeviceOwnership(device models.Device, customer string, siteIdentifier string) error {
+	if !strings.EqualFold(device.Site.Customer.Name, customer) {
+		return &OwnershipError{
+			DeviceIdentifier: device.DeviceIdentifier,
+			Field:            "customer",
+			Expected:         customer,
+			Actual:           device.Site.Customer.Name,
+		}
 	}
 
-	return devices, nil
-}
-
-// Helper function to get devices by controller identifier
-func GetDevicesByControllerIdentifier(controllerIdentifier string) ([]models.Device, error) {
-	bmsDB, err := getDBInstance()
-	if err != nil {
-		return nil, err
+	if siteIdentifier == "" {
+		return nil
 	}
 
-	var devices []models.Device
-	if err := bmsDB.DB.Preload("Site.Customer").Where("controller_identifier = ?", controllerIdentifier).Find(&devices).Error; err != nil {
-		return nil, fmt.Errorf("failed to get devices: %w", err)
+	if !strings.EqualFold(device.Site.ID.String(), siteIdentifier) && !strings.EqualFold(device.Site.Name, siteIdentifier) {
+		return &OwnershipError{
+			DeviceIdentifier: device.DeviceIdentifier,
+			Field:            "site",
+			Expected:         siteIdentifier,
+			Actual:           device.Site.Name,
+		}
 	}
 
-	return devices, nil
+	return nil
 }
 
-// Helper function to get device by device identifier
-func GetDevicesByDeviceIdentifier(deviceIdentifier string) (models.Device, error) {
-	bmsDB, err := getDBInstance()
-	if err != nil {
-		return models.Device{}, err
-	}
-
-	var device models.Device
-	if err := bmsDB.DB.Preload("Site.Customer").Where("device_identifier = ?", deviceIdentifier).First(&device).Error; err != nil {
-		return models.Device{}, fmt.Errorf("failed to get device: %w", err)
-	}
+// Helper function to set the path of the ignore rules file
+func SetIgnoredFilePath(filePath string) {
+	ignoredCache.mu.Lock()
+	defer ignoredCache.mu.Unlock()
 
-	return device, nil
+	ignoredCache.filePath = filePath
+	ignoredCache.list = nil
 }
 
-// Helper function to validate and retrieve customer
-func GetValidCustomer(topic string) (string, error) {
-	customer, err := getCustomerFromTopic(topic)
+// Helper function to reload the ignore rules now, returning the number of rules.
+// The cached rules are kept if the file cannot be read.
+func ReloadIgnored() (int, error) {
+	ignoredCache.mu.Lock()
+	defer ignoredCache.mu.Unlock()
+
+	info, err := os.Stat(ignoredCache.filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to get customer: %w", err)
+		return 0, fmt.Errorf("error opening file: %w", err)
 	}
 
-	customers, err := GetAllCustomers()
+	list, err := ignored.Load(ignoredCache.filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to get customers: %w", err)
+		return 0, err
 	}
 
-	for _, c := range customers {
-		if strings.EqualFold(c.Name, customer) {
-			return customer, nil
-		}
-	}
+	ignoredCache.list = list
+	ignoredCache.modTime = info.ModTime()
 
-	return "", fmt.Errorf("customer not found: %s", customer)
+	return len(list.Rules), nil
 }
 
-// Helper function to read ignored controllers from json file
-func readIgnoredFile() (*types.IgnoredControllersAndDevices, error) {
-	// Open the JSON file
-	file, err := os.Open("./internal/workers/ignored/ignored.json")
+// Helper function to read the ignore rules, reloading them when the file changes
+func getIgnored() (*types.IgnoredControllersAndDevices, error) {
+	ignoredCache.mu.Lock()
+	defer ignoredCache.mu.Unlock()
+
+	info, err := os.Stat(ignoredCache.filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %w", err)
 	}
-	defer file.Close()
 
-	// Decode the JSON file into the Config struct
-	var ignoredControllersAndDevices types.IgnoredControllersAndDevices
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&ignoredControllersAndDevices)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding JSON: %w", err)
+	if ignoredCache.list != nil && ignoredCache.modTime.Equal(info.ModTime()) {
+		return ignoredCache.list, nil
 	}
 
-	return &ignoredControllersAndDevices, nil
-}
-
-// Helper function to read and return ignored controllers from json file
-func GetIgnoredControllers() ([]string, error) {
-	ignoredControllersAndDevices, err := readIgnoredFile()
+	list, err := ignored.Load(ignoredCache.filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read ignored controllers: %w", err)
+		return nil, err
 	}
 
-	return ignoredControllersAndDevices.IgnoredControllers, nil
+	ignoredCache.list = list
+	ignoredCache.modTime = info.ModTime()
+
+	return list, nil
 }
 
-// Helper function to read and return ignored devices from json file
-func GetIgnoredDevices() ([]string, error) {
-	ignoredControllersAndDevices, err := readIgnoredFile()
+// Helper function to check whether a controller or device is ignored
+func CheckIgnored(kind string, customer string, site string, identifier string) error {
+	list, err := getIgnored()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read ignored devices: %w", err)
+		return fmt.Errorf("failed to read ignore rules: %w", err)
+	}
+
+	scope := ignored.Scope{Customer: customer, Site: site, Identifier: identifier}
+	if rule, ok := ignored.Find(list, kind, scope, time.Now()); ok {
+		return &IgnoredError{Kind: kind, Identifier: identifier, Rule: *rule}
 	}
 
-	return ignoredControllersAndDevices.IgnoredDevices, nil
+	return nil
 }
 
 // Helper function to check if a DataStruct is empty
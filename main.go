@@ -6,35 +6,38 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"github.com/johandrevandeventer/logging"
 	"github.com/johandrevandeventer/mqtt-worker/cmd"
 	"github.com/johandrevandeventer/mqtt-worker/initializers"
 	"github.com/johandrevandeventer/mqtt-worker/internal/config"
 	"github.com/johandrevandeventer/mqtt-worker/internal/engine"
+	"github.com/johandrevandeventer/mqtt-worker/internal/flags"
+	"github.com/johandrevandeventer/mqtt-worker/internal/loglevel"
 	coreutils "github.com/johandrevandeventer/mqtt-worker/utils"
-	"github.com/johandrevandeventer/splashscreen"
 	"github.com/johandrevandeventer/textutils"
 	"go.uber.org/zap"
 )
 
 func main() {
-	splashscreen.PrintSplashScreen()
+	// Load the environment before the commands run, so that subcommands see the
+	// .env values in the effective config too. Only running the engine requires
+	// the file.
+	envErr := initializers.LoadEnvVariable()
 
 	cmd.Execute()
 
 	// Initialize the environment
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Loading environment variables..."))
-	err := initializers.LoadEnvVariable()
-	if err != nil {
-		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+	if envErr != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, envErr.Error()))
 		return
 	}
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> Environment variables loaded"))
 
 	// Initialize the configuration
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing configuration files..."))
-	err = initializers.InitConfig()
+	err := initializers.InitConfig()
 	if err != nil {
 		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
 		return
@@ -42,9 +45,23 @@ func main() {
 
 	// Initialize the logger
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing Logger..."))
-	cfg := config.GetConfig()
-	initializers.InitLogger(cfg)
-	logger := logging.GetLogger("main")
+	cfg, err := config.GetConfig()
+	if err != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+		return
+	}
+
+	if flags.FlagPrintConfig {
+		cfg.PrintEffectiveConfig(os.Stdout)
+		return
+	}
+
+	err = initializers.InitLogger(cfg)
+	if err != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+		return
+	}
+	logger := loglevel.Logger("main")
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> Logger initialized"))
 
 	// Initialize the state persistence
@@ -56,14 +73,40 @@ func main() {
 	}
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> State persistence initialized"))
 
+	// Initialize tracing
+	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing tracing..."))
+	shutdownTracing, err := initializers.InitTracing(cfg)
+	if err != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("Failed to flush traces", zap.Error(err))
+		}
+	}()
+	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> Tracing initialized"))
+
+	// Initialize the device repository
+	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing device repository..."))
+	devices, err := initializers.InitDeviceRepository(cfg)
+	if err != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+		return
+	}
+	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> Device repository initialized"))
+
 	coreutils.VerbosePrintln("")
 
-	// Graceful shutdown handling
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	// Graceful shutdown handling, SIGHUP, SIGUSR1 and SIGUSR2 are handled by the engine
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	// Create the engine
 	engine := engine.NewEngine(ctx, cfg, logger, statePersister)
+	engine.SetDeviceRepository(devices)
 
 	// Recover from panics
 	defer func() {
@@ -58,6 +58,11 @@ func GetConnectionsDir() string {
 	return filepath.Join(GetRuntimeDir(), "connections")
 }
 
+// Get the discovery directory
+func GetDiscoveryDir() string {
+	return filepath.Join(GetRuntimeDir(), "discovery")
+}
+
 // FileExists checks if a file exists
 func FileExists(filePath string) bool {
 	_, err := os.Stat(filePath)